@@ -0,0 +1,194 @@
+package htmx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// TemplateCache stores parsed templates by a key that identifies the filesystem, the templates and the functions.
+	// The cache can be swapped per htmx instance with HTMX.SetTemplateCache.
+	TemplateCache interface {
+		Load(key string) (*template.Template, bool)
+		Store(key string, tmpl *template.Template)
+		Clear()
+	}
+
+	// memoryTemplateCache is the default in memory TemplateCache
+	memoryTemplateCache struct {
+		templates sync.Map
+	}
+)
+
+// NewTemplateCache returns a new in memory template cache.
+func NewTemplateCache() TemplateCache {
+	return &memoryTemplateCache{}
+}
+
+// Load returns the cached template for the key
+func (c *memoryTemplateCache) Load(key string) (*template.Template, bool) {
+	tmpl, ok := c.templates.Load(key)
+	if !ok {
+		return nil, false
+	}
+
+	return tmpl.(*template.Template), true
+}
+
+// Store caches the template for the key
+func (c *memoryTemplateCache) Store(key string, tmpl *template.Template) {
+	c.templates.Store(key, tmpl)
+}
+
+// Clear removes all cached templates
+func (c *memoryTemplateCache) Clear() {
+	c.templates.Range(func(key, _ any) bool {
+		c.templates.Delete(key)
+		return true
+	})
+}
+
+// TemplateCacheKey returns the cache key for the templates parsed from the filesystem with the functions of a component,
+// together with the DefaultTemplateFuncs. The key is built from the identity of the filesystem, the full ordered list
+// of templates and the name and implementation of every function.
+// The second value is false when a function of the component is a closure or a method value, their implementation is
+// shared by every instance, so functions with the same name can behave differently under the same key and must not be cached.
+// The DefaultTemplateFuncs are shared by every component, so they are identified by their name when they are closures.
+func TemplateCacheKey(fsys fs.FS, templates []string, funcs template.FuncMap) (string, bool) {
+	key, uncacheable := templateCacheKey(fsys, templates, funcs, "")
+	return key, uncacheable == ""
+}
+
+// templateCacheKey returns the cache key like TemplateCacheKey does, together with the name of the function that
+// makes the templates uncacheable, or an empty name when they can be cached.
+// When funcSetKey is not empty it identifies the functions of the component instead of their implementation,
+// so closures and method values can be cached.
+func templateCacheKey(fsys fs.FS, templates []string, funcs template.FuncMap, funcSetKey string) (string, string) {
+	names := make([]string, 0, len(funcs)+len(DefaultTemplateFuncs))
+	for name := range DefaultTemplateFuncs {
+		if _, own := funcs[name]; !own {
+			names = append(names, name)
+		}
+	}
+
+	for name := range funcs {
+		names = append(names, name)
+	}
+	// Sort function names to ensure consistent ordering
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range templates {
+		_, _ = fmt.Fprintf(hash, "template:%q\n", name)
+	}
+
+	if funcSetKey != "" {
+		_, _ = fmt.Fprintf(hash, "funcset:%q\n", funcSetKey)
+	}
+
+	for _, name := range names {
+		fn, own := funcs[name]
+		if !own {
+			fn = DefaultTemplateFuncs[name]
+		}
+
+		if own && funcSetKey != "" {
+			_, _ = fmt.Fprintf(hash, "func:%q\n", name)
+			continue
+		}
+
+		identity, ok := funcIdentity(fn)
+		if !ok {
+			if own {
+				return "", name
+			}
+
+			identity = "default"
+		}
+
+		_, _ = fmt.Fprintf(hash, "func:%q=%s\n", name, identity)
+	}
+
+	return fsIdentity(fsys) + ":" + hex.EncodeToString(hash.Sum(nil)), ""
+}
+
+// uncachedTemplates holds the templates that were reported as not cacheable, so every set is reported once
+var uncachedTemplates sync.Map
+
+// warnUncached reports once that the templates are parsed on every render because of the function
+func warnUncached(log Logger, templates []string, function string) {
+	key := strings.Join(templates, "\x00") + "\x00" + function
+	if _, reported := uncachedTemplates.LoadOrStore(key, true); reported {
+		return
+	}
+
+	if log == nil {
+		log = slog.Default().WithGroup("htmx")
+	}
+
+	log.Warn("templates are parsed on every render because the template function is a closure or method value, set a FuncSetKey to cache them",
+		"templates", templates, "function", function)
+}
+
+// fsIdentity returns a stable identity of the filesystem for the lifetime of the process.
+// Filesystems of a reference kind (like fstest.MapFS) are identified by their address, others by their value.
+func fsIdentity(fsys fs.FS) string {
+	if fsys == nil {
+		return "<nil>"
+	}
+
+	v := reflect.ValueOf(fsys)
+	switch v.Kind() {
+	case reflect.Map, reflect.Pointer, reflect.Slice, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return fmt.Sprintf("%T@%x", fsys, v.Pointer())
+	default:
+		return fmt.Sprintf("%T:%v", fsys, fsys)
+	}
+}
+
+// funcIdentity returns the identity of the implementation of a template function.
+// The second value is false for closures and method values, which share their implementation
+// with every other closure of the same function literal or method value of the same method.
+func funcIdentity(fn any) (string, bool) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return fmt.Sprintf("%T", fn), true
+	}
+
+	f := runtime.FuncForPC(v.Pointer())
+	if f == nil || isClosure(f.Name()) {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s@%x", v.Type(), v.Pointer()), true
+}
+
+// isClosure returns true when the runtime name of a function is the name of a function literal, like pkg.outer.func1
+// or pkg.outer.func1.2, or of a method value, like pkg.T.Method-fm
+func isClosure(name string) bool {
+	if strings.HasSuffix(name, "-fm") {
+		return true
+	}
+
+	last := strings.TrimPrefix(name[strings.LastIndexByte(name, '.')+1:], "func")
+	if last == "" {
+		return false
+	}
+
+	for _, r := range last {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
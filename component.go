@@ -1,33 +1,36 @@
 package htmx
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"net/url"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
-	"sync"
 )
 
 var (
 	DefaultTemplateFuncs = template.FuncMap{}
 	UseTemplateCache     = true
-	templateCache        = sync.Map{} // Cache for parsed templates
+
+	// DefaultTemplateCache is the cache for parsed templates used by Component.Render and new htmx instances
+	DefaultTemplateCache = NewTemplateCache()
 )
 
 type (
 	RenderableComponent interface {
 		Render(ctx context.Context) (template.HTML, error)
+		RenderFragment(ctx context.Context, name string) (template.HTML, error)
 		Wrap(renderer RenderableComponent, target string) RenderableComponent
 		With(r RenderableComponent, target string) RenderableComponent
 		Attach(target string) RenderableComponent
+		Target(target string, block string) RenderableComponent
+		Slot(name string, block string) RenderableComponent
+		SlotComponent(name string, r RenderableComponent) RenderableComponent
 		SetData(input map[string]any) RenderableComponent
 		AddData(key string, value any) RenderableComponent
 		SetGlobalData(input map[string]any) RenderableComponent
@@ -35,89 +38,300 @@ type (
 		AddTemplateFunction(name string, function interface{}) RenderableComponent
 		AddTemplateFunctions(funcs template.FuncMap) RenderableComponent
 		SetURL(url *url.URL)
+		Instance() RenderableComponent
 		Reset() *Component
 
 		data() map[string]any
-		injectData(input map[string]any)
-		injectGlobalData(input map[string]any)
-		addPartial(key string, value any)
 		partials() map[string]RenderableComponent
 		isWrapped() bool
 		wrapper() RenderableComponent
 		target() string
+		targetBlocks() map[string]string
+		slotComponents() map[string]RenderableComponent
+		model() (any, bool)
+		templateNames() []string
+		renderSlots(ctx context.Context, in renderInput) (map[string]template.HTML, error)
+		render(ctx context.Context, in renderInput) (template.HTML, error)
+		execute(ctx context.Context, w io.Writer, in renderInput) error
+		precompile(cache TemplateCache, log Logger) error
+		streamsPartial(target string, cache TemplateCache, log Logger) bool
 	}
 
+	// Component holds the templates, functions, filesystem and the wrap/with structure, together with the data to render.
+	// Rendering never modifies the component, so a component that is defined once can be rendered concurrently.
+	// Use Instance to get a cheap per-request copy to set the request data on.
 	Component struct {
 		templateData    map[string]any
 		with            map[string]RenderableComponent
-		partial         map[string]any
 		globalData      map[string]any
 		wrappedRenderer RenderableComponent
 		wrappedTarget   string
+		targets         map[string]string
+		slots           map[string]slot
+		modelValue      any
+		hasModel        bool
 		templates       []string
 		url             *url.URL
 		functions       template.FuncMap
+		funcSetKey      string
 		fs              fs.FS
 	}
+
+	// renderInput is the per-render state that is handed down to partials and up to wrappers
+	renderInput struct {
+		data     map[string]any           // inherited data, the data of the component itself takes precedence
+		global   map[string]any           // inherited global data, the global data of the component itself takes precedence
+		partials map[string]any           // rendered output of other components, like the wrapped child
+		url      *url.URL                 // overrides the url of the component
+		block    string                   // the {{define}} or {{block}} to execute, the first template when empty
+		cache    TemplateCache            // the cache for parsed templates, DefaultTemplateCache when nil
+		log      Logger                   // the logger for template warnings, the default slog logger when nil
+		slots    map[string]template.HTML // the slots filled by the wrapped children
+		model    any                      // inherited model, the model of the component itself takes precedence
+		hasModel bool                     // whether model is set, a model can be a nil value
+		lazy     map[string]streamFunc    // partials that are executed when the template reaches them, see renderData.Partial
+		rendered renderedPartials         // the With partials rendered for the components of one request, shared by their slots
+	}
+
+	// renderedPartials holds the rendered With partials per component, so a component and its block slots render them once
+	renderedPartials map[*Component]map[string]any
+
+	// streamFunc executes a component into the writer
+	streamFunc func(w io.Writer) error
+
+	// slot is the content a component contributes to a named region of its wrappers,
+	// either a block of its own templates or another component
+	slot struct {
+		block     string
+		component RenderableComponent
+	}
 )
 
 func NewComponent(templates ...string) *Component {
 	return &Component{
 		templateData: make(map[string]any),
 		functions:    make(template.FuncMap),
-		partial:      make(map[string]any),
 		with:         make(map[string]RenderableComponent),
 		templates:    templates,
 		fs:           os.DirFS("./"),
 	}
 }
 
+// Instance returns a per-request copy of the component that shares the templates, functions, filesystem and the
+// wrap/with structure. Data, global data and the url set on the instance do not affect the original component,
+// which makes it possible to define components once at startup and reuse them across requests.
+func (c *Component) Instance() RenderableComponent {
+	instance := &Component{
+		templateData:    copyMap(c.templateData),
+		globalData:      copyMap(c.globalData),
+		with:            make(map[string]RenderableComponent, len(c.with)),
+		wrappedRenderer: c.wrappedRenderer,
+		wrappedTarget:   c.wrappedTarget,
+		templates:       c.templates[:len(c.templates):len(c.templates)],
+		functions:       make(template.FuncMap, len(c.functions)),
+		modelValue:      c.modelValue,
+		hasModel:        c.hasModel,
+		funcSetKey:      c.funcSetKey,
+		fs:              c.fs,
+	}
+
+	for key, value := range c.with {
+		instance.with[key] = value
+	}
+
+	for key, value := range c.functions {
+		instance.functions[key] = value
+	}
+
+	if c.slots != nil {
+		instance.slots = make(map[string]slot, len(c.slots))
+		for key, value := range c.slots {
+			instance.slots[key] = value
+		}
+	}
+
+	if c.targets != nil {
+		instance.targets = make(map[string]string, len(c.targets))
+		for key, value := range c.targets {
+			instance.targets[key] = value
+		}
+	}
+
+	return instance
+}
+
 // FS sets the filesystem to load templates from, this allows for embedding templates into the go binary.
 func (c *Component) FS(fsys fs.FS) *Component {
 	c.fs = fsys
 	return c
 }
 
+// FuncSetKey identifies the template functions of the component for the template cache.
+// Closures and method values can't be told apart by their implementation, so templates using them are parsed
+// on every render. Components that use the same key must use functions that behave the same, like a translator
+// for the same locale keyed by that locale.
+func (c *Component) FuncSetKey(key string) *Component {
+	c.funcSetKey = key
+	return c
+}
+
 // Render renders the given templates with the given data
 // it has all the default template functions and the additional template functions
 // that are added with AddTemplateFunction
 func (c *Component) Render(ctx context.Context) (template.HTML, error) {
+	return c.render(ctx, renderInput{})
+}
+
+// RenderFragment renders a single named {{define}} or {{block}} of the component's templates,
+// with the same data, global data, partials and url as the full component.
+// This keeps the full page and the fragment in one template file.
+func (c *Component) RenderFragment(ctx context.Context, name string) (template.HTML, error) {
+	if name == "" {
+		return "", errors.New("no fragment name provided for rendering")
+	}
+
+	return c.render(ctx, renderInput{block: name})
+}
+
+// render renders the component into a buffer, the component itself is not modified
+func (c *Component) render(ctx context.Context, in renderInput) (template.HTML, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := c.execute(ctx, buf, in); err != nil {
+		return "", err
+	}
+
+	return template.HTML(buf.String()), nil
+}
+
+// execute renders the partials and executes the templates into the writer, the component itself is not modified
+func (c *Component) execute(ctx context.Context, w io.Writer, in renderInput) error {
 	// Check for circular references
 	if ctx.Value(c) != nil {
-		return "", errors.New("circular reference detected in partials")
+		return renderError(c.templates, errors.New("circular reference detected in partials"))
 	}
 
 	// Add current component to context
 	ctx = context.WithValue(ctx, c, true)
 
-	for key, value := range c.partials() {
-		value.injectData(c.templateData)
-		value.injectGlobalData(c.globalData)
+	data := mergeMap(c.templateData, in.data)
+	global := mergeMap(c.globalData, in.global)
 
-		ch, err := value.Render(ctx)
-		if err != nil {
-			return "", err
+	u := c.url
+	if in.url != nil {
+		u = in.url
+	}
+
+	model, hasModel := in.model, in.hasModel
+	if c.hasModel {
+		model, hasModel = c.modelValue, true
+	}
+
+	partials := make(map[string]any, len(in.partials)+len(c.with))
+	for key, value := range in.partials {
+		partials[key] = value
+	}
+
+	with, ok := in.rendered[c]
+	if !ok {
+		with = make(map[string]any, len(c.with))
+		for key, value := range c.partials() {
+			ch, err := value.render(ctx, renderInput{data: data, global: global, url: u, cache: in.cache, log: in.log, model: model, hasModel: hasModel})
+			if err != nil {
+				return partialError(key, c.templates, err)
+			}
+			with[key] = ch
+		}
+
+		if in.rendered != nil {
+			in.rendered[c] = with
 		}
-		c.addPartial(key, ch)
+	}
+
+	for key, value := range with {
+		partials[key] = value
 	}
 
 	//get the name of the first template file
 	if len(c.templates) == 0 {
-		return "", errors.New("no templates provided for rendering")
+		return renderError(c.templates, errors.New("no templates provided for rendering"))
 	}
 
-	return c.renderNamed(ctx, filepath.Base(c.templates[0]), c.templates, c.templateData)
+	err := c.renderNamed(w, filepath.Base(c.templates[0]), c.templates, renderData{
+		Ctx:      ctx,
+		Data:     data,
+		Global:   global,
+		Partials: partials,
+		Slots:    in.slots,
+		URL:      u,
+		Model:    model,
+		lazy:     in.lazy,
+		w:        w,
+	}, in.block, in.cache, in.log)
+	if err != nil {
+		return renderError(c.templates, err)
+	}
+
+	return nil
 }
 
-// renderNamed renders the given templates with the given data
+// renderData is the data that is available in the templates
+type renderData struct {
+	Ctx      context.Context
+	Data     map[string]any
+	Global   map[string]any
+	Partials map[string]any
+	Slots    map[string]template.HTML
+	URL      *url.URL
+	Model    any
+
+	lazy map[string]streamFunc
+	w    io.Writer
+}
+
+// Partial returns the partial with the given name, like .Partials does.
+// When the layout is rendered with Handler.RenderStream, the wrapped component is executed straight into the response
+// at the point where the layout reaches {{ .Partial "name" }}, so it must be used as a plain output action.
+func (d renderData) Partial(name string) (any, error) {
+	if fn, ok := d.lazy[name]; ok {
+		return template.HTML(""), fn(d.w)
+	}
+
+	return d.Partials[name], nil
+}
+
+// renderNamed executes the given templates with the given data into the writer
 // it has all the default template functions and the additional template functions
 // that are added with AddTemplateFunction
-func (c *Component) renderNamed(ctx context.Context, name string, templates []string, input map[string]any) (template.HTML, error) {
+// when block is not empty, only the {{define}} or {{block}} with that name is executed
+func (c *Component) renderNamed(w io.Writer, name string, templates []string, data renderData, block string, cache TemplateCache, log Logger) error {
 	if len(templates) == 0 {
-		return "", nil
+		return nil
+	}
+
+	t, err := c.parse(name, templates, cache, log)
+	if err != nil {
+		return err
+	}
+
+	if block == "" {
+		block = name
 	}
 
-	var err error
+	return t.ExecuteTemplate(w, block, data)
+}
+
+// parse returns the parsed templates from the cache, or parses and caches them
+func (c *Component) parse(name string, templates []string, cache TemplateCache, log Logger) (*template.Template, error) {
+	tmpl, _, err := c.parseTargets(name, templates, cache, log)
+	return tmpl, err
+}
+
+// parseTargets returns the parsed templates like parse does, together with the targets of the {{ .Partial }} calls.
+// The targets are collected right after parsing, before the templates are shared and executed.
+// Templates that can't be cached because of a closure or method value are reported once through the logger.
+func (c *Component) parseTargets(name string, templates []string, cache TemplateCache, log Logger) (*template.Template, map[string]bool, error) {
 	functions := make(template.FuncMap)
 	for key, value := range DefaultTemplateFuncs {
 		functions[key] = value
@@ -129,42 +343,72 @@ func (c *Component) renderNamed(ctx context.Context, name string, templates []st
 		}
 	}
 
-	cacheKey := generateCacheKey(templates, functions)
-	tmpl, cached := templateCache.Load(cacheKey)
-	if !cached || !UseTemplateCache {
-		// Parse and cache template as before
-		tmpl, err = template.New(name).Funcs(functions).ParseFS(c.fs, templates...)
+	if cache == nil {
+		cache = DefaultTemplateCache
+	}
+
+	cacheKey, uncacheable := templateCacheKey(c.fs, templates, c.functions, c.funcSetKey)
+	if uncacheable != "" {
+		warnUncached(log, templates, uncacheable)
+
+		tmpl, err := template.New(name).Funcs(functions).ParseFS(c.fs, templates...)
 		if err != nil {
-			return "", err
+			return nil, nil, err
 		}
-		templateCache.Store(cacheKey, tmpl)
+
+		return tmpl, partialTargets(tmpl), nil
 	}
 
-	data := struct {
-		Ctx      context.Context
-		Data     map[string]any
-		Global   map[string]any
-		Partials map[string]any
-		URL      *url.URL
-	}{
-		Ctx:      ctx,
-		Data:     input,
-		Global:   c.globalData,
-		Partials: c.partial,
-		URL:      c.url,
+	if tmpl, cached := cache.Load(cacheKey); cached && UseTemplateCache {
+		targets, _ := streamTargets.Load(cacheKey)
+		partials, _ := targets.(map[string]bool)
+
+		return tmpl, partials, nil
 	}
 
-	if t, ok := tmpl.(*template.Template); ok {
-		var buf bytes.Buffer
-		err = t.Execute(&buf, data)
-		if err != nil {
-			return "", err
-		}
+	// record the files before parsing, so changes made while parsing are noticed
+	if watcher, ok := cache.(templateWatcher); ok {
+		watcher.Watch(cacheKey, c.fs, templates)
+	}
+
+	tmpl, err := template.New(name).Funcs(functions).ParseFS(c.fs, templates...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targets := partialTargets(tmpl)
+	streamTargets.Store(cacheKey, targets)
+	cache.Store(cacheKey, tmpl)
+
+	return tmpl, targets, nil
+}
+
+// precompile parses the templates of the component into the cache, without rendering them
+func (c *Component) precompile(cache TemplateCache, log Logger) error {
+	if len(c.templates) == 0 {
+		return errors.New("no templates provided for rendering")
+	}
 
-		return template.HTML(buf.String()), nil // Return rendered content
+	if _, err := c.parse(filepath.Base(c.templates[0]), c.templates, cache, log); err != nil {
+		return fmt.Errorf("component %v: %w", c.templates, err)
 	}
 
-	return "", errors.New("template is not a *template.Template")
+	return nil
+}
+
+// streamsPartial returns true when the templates call {{ .Partial "target" }}, so a wrapped component can be
+// executed straight into the response by Handler.RenderStream
+func (c *Component) streamsPartial(target string, cache TemplateCache, log Logger) bool {
+	if len(c.templates) == 0 {
+		return false
+	}
+
+	_, targets, err := c.parseTargets(filepath.Base(c.templates[0]), c.templates, cache, log)
+	if err != nil {
+		return false
+	}
+
+	return targets[target]
 }
 
 // Wrap wraps the component with the given renderer
@@ -181,10 +425,6 @@ func (c *Component) With(r RenderableComponent, target string) RenderableCompone
 		c.with = make(map[string]RenderableComponent)
 	}
 
-	if c.url != nil {
-		r.SetURL(c.url)
-	}
-
 	c.with[target] = r
 
 	return c
@@ -200,6 +440,90 @@ func (c *Component) Attach(target string) RenderableComponent {
 	return c
 }
 
+// Target renders only the named {{define}} block of the templates when the HX-Target of a partial request matches the target id.
+// Other requests still render the whole component.
+func (c *Component) Target(target string, block string) RenderableComponent {
+	if c.targets == nil {
+		c.targets = make(map[string]string)
+	}
+
+	c.targets[strings.TrimPrefix(target, "#")] = block
+
+	return c
+}
+
+// Slot fills the named slot of the wrappers with the named {{define}} or {{block}} of the templates of the component.
+// This lets a page contribute to several regions of its layout, like the title, the head or the sidebar.
+// Wrappers read the slots with {{ .Slots.name }}, and can fall back to a default with {{ with .Slots.name }}{{ . }}{{ else }}...{{ end }}.
+func (c *Component) Slot(name string, block string) RenderableComponent {
+	if c.slots == nil {
+		c.slots = make(map[string]slot)
+	}
+
+	c.slots[name] = slot{block: block}
+
+	return c
+}
+
+// SlotComponent fills the named slot of the wrappers with the output of the given component
+func (c *Component) SlotComponent(name string, r RenderableComponent) RenderableComponent {
+	if c.slots == nil {
+		c.slots = make(map[string]slot)
+	}
+
+	c.slots[name] = slot{component: r}
+
+	return c
+}
+
+// renderSlots renders the slots of the component with the same data, global data and url as the component itself.
+// Block slots share the With partials rendered for the component, they are rendered once for all of them.
+func (c *Component) renderSlots(ctx context.Context, in renderInput) (map[string]template.HTML, error) {
+	if len(c.slots) == 0 {
+		return nil, nil
+	}
+
+	if in.rendered == nil {
+		in.rendered = make(renderedPartials)
+	}
+
+	slots := make(map[string]template.HTML, len(c.slots))
+	for name, s := range c.slots {
+		var (
+			output template.HTML
+			err    error
+		)
+
+		if s.component != nil {
+			model, hasModel := c.model()
+			if !hasModel {
+				model, hasModel = in.model, in.hasModel
+			}
+
+			output, err = s.component.render(ctx, renderInput{
+				data:     mergeMap(c.templateData, in.data),
+				global:   mergeMap(c.globalData, in.global),
+				url:      in.url,
+				cache:    in.cache,
+				log:      in.log,
+				model:    model,
+				hasModel: hasModel,
+			})
+		} else {
+			in.block = s.block
+			output, err = c.render(ctx, in)
+		}
+
+		if err != nil {
+			return nil, partialError(name, c.templates, err)
+		}
+
+		slots[name] = output
+	}
+
+	return slots, nil
+}
+
 func (c *Component) AddTemplateFunction(name string, function interface{}) RenderableComponent {
 	if c.functions == nil {
 		c.functions = make(template.FuncMap)
@@ -263,13 +587,10 @@ func (c *Component) AddData(key string, value any) RenderableComponent {
 	return c
 }
 
+// SetURL sets the url of the component, it is handed to the partials and wrappers while rendering and replaces their own url.
+// Handler.Render renders with the url of the request, which replaces the url of the component as well.
 func (c *Component) SetURL(url *url.URL) {
 	c.url = url
-
-	// Recursively set the URL for all partials
-	for _, partial := range c.with {
-		partial.SetURL(url)
-	}
 }
 
 // isWrapped returns true if the component is wrapped
@@ -287,35 +608,36 @@ func (c *Component) target() string {
 	return c.wrappedTarget
 }
 
-// partials returns the partials
-func (c *Component) partials() map[string]RenderableComponent {
-	return c.with
+// targetBlocks returns the blocks keyed by target id
+func (c *Component) targetBlocks() map[string]string {
+	return c.targets
 }
 
-// injectData injects the input data into the template data
-func (c *Component) injectData(input map[string]any) {
-	for key, value := range input {
-		if _, ok := c.templateData[key]; !ok {
-			c.templateData[key] = value
+// slotComponents returns the components that fill slots
+func (c *Component) slotComponents() map[string]RenderableComponent {
+	components := make(map[string]RenderableComponent)
+	for name, s := range c.slots {
+		if s.component != nil {
+			components[name] = s.component
 		}
 	}
+
+	return components
 }
 
-func (c *Component) injectGlobalData(input map[string]any) {
-	if c.globalData == nil {
-		c.globalData = make(map[string]any)
-	}
+// model returns the model of the component, the second value reports whether a model is set
+func (c *Component) model() (any, bool) {
+	return c.modelValue, c.hasModel
+}
 
-	for key, value := range input {
-		if _, ok := c.globalData[key]; !ok {
-			c.globalData[key] = value
-		}
-	}
+// templateNames returns the templates of the component
+func (c *Component) templateNames() []string {
+	return c.templates
 }
 
-// addPartial adds a partial to the component
-func (c *Component) addPartial(key string, value any) {
-	c.partial[key] = value
+// partials returns the partials
+func (c *Component) partials() map[string]RenderableComponent {
+	return c.with
 }
 
 // data returns the template data
@@ -323,24 +645,37 @@ func (c *Component) data() map[string]any {
 	return c.templateData
 }
 
+// Reset clears the data, global data, partials, slots and url of the component.
+// Rendering does not modify the component, use Instance to get a fresh per-request copy instead.
 func (c *Component) Reset() *Component {
 	c.templateData = make(map[string]any)
 	c.globalData = make(map[string]any)
-	c.partial = make(map[string]any)
 	c.with = make(map[string]RenderableComponent)
+	c.slots = nil
 	c.url = nil
 
 	return c
 }
 
-// Generate a hash of the function names to include in the cache key
-func generateCacheKey(templates []string, funcs template.FuncMap) string {
-	var funcNames []string
-	for name := range funcs {
-		funcNames = append(funcNames, name)
+// mergeMap returns a new map with the values of both maps, the values of own take precedence over the inherited ones
+func mergeMap(own, inherited map[string]any) map[string]any {
+	merged := make(map[string]any, len(own)+len(inherited))
+	for key, value := range inherited {
+		merged[key] = value
+	}
+
+	for key, value := range own {
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// copyMap returns a shallow copy of the map, nil stays nil
+func copyMap(input map[string]any) map[string]any {
+	if input == nil {
+		return nil
 	}
-	// Sort function names to ensure consistent ordering
-	sort.Strings(funcNames)
-	hash := sha256.Sum256([]byte(strings.Join(funcNames, ",")))
-	return templates[0] + ":" + hex.EncodeToString(hash[:])
+
+	return mergeMap(input, nil)
 }
@@ -0,0 +1,648 @@
+package htmx
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+var testFS = fstest.MapFS{
+	"index.html":   {Data: []byte(`<html>{{ .Partials.Content }}</html>`)},
+	"page.html":    {Data: []byte(`<main>{{ .Data.Title }}</main>`)},
+	"counter.html": {Data: []byte(`<span id="counter">{{ .Data.Count }}</span>`)},
+	"flash.html":   {Data: []byte(`<p>{{ .Data.Message }}</p>`)},
+	"search.html": {Data: []byte(`<form>{{ .Data.Query }}</form>{{ block "results" . }}<ul>{{ range .Data.Results }}<li>{{ . }}</li>{{ end }}</ul>{{ end }}` +
+		`{{ define "count" }}<span>{{ len .Data.Results }}</span>{{ end }}`)},
+}
+
+func newTestComponent(templates ...string) *Component {
+	return NewComponent(templates...).FS(testFS)
+}
+
+func TestRenderOOB(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("HX-Request", "true")
+
+	h := New().NewHandler(w, r)
+	h.AddOOB("#counter", nil, newTestComponent("counter.html").AddData("Count", 3))
+	h.AddOOB("#flash", NewSwap().Style(SwapBeforeEnd), newTestComponent("flash.html").AddData("Message", "saved"))
+
+	page := newTestComponent("page.html").AddData("Title", "Cart").Wrap(newTestComponent("index.html"), "Content")
+
+	_, err := h.Render(context.Background(), page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<main>Cart</main>` +
+		`<span hx-swap-oob="outerHTML:#counter" id="counter">3</span>` +
+		`<div hx-swap-oob="beforeend:#flash"><p>saved</p></div>`
+
+	equal(t, expected, w.Body.String())
+}
+
+func TestRenderOOBTableRows(t *testing.T) {
+	rowFS := fstest.MapFS{
+		"row.html": {Data: []byte(`<tr><td>{{ .Data.Name }}</td></tr>`)},
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("HX-Request", "true")
+
+	h := New().NewHandler(w, r)
+	h.AddOOB("#rows", NewSwap().Style(SwapBeforeEnd), NewComponent("row.html").FS(rowFS).AddData("Name", "htmx"))
+
+	if _, err := h.Render(context.Background(), newTestComponent("page.html").AddData("Title", "Rows")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a div would make the html parser drop the row, the tbody inside a template keeps it
+	equal(t, `<main>Rows</main><template><tbody hx-swap-oob="beforeend:#rows"><tr><td>htmx</td></tr></tbody></template>`, w.Body.String())
+}
+
+func TestOOBStamp(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected string
+	}{
+		{`<p>a</p>`, `<p hx-swap-oob="outerHTML:#x">a</p>`},
+		{"\n  <div class=\"a>b\"><p>a</p><br><img src=\"x\"/></div>\n", "\n  <div hx-swap-oob=\"outerHTML:#x\" class=\"a>b\"><p>a</p><br><img src=\"x\"/></div>\n"},
+		{`<input name="q">`, `<input hx-swap-oob="outerHTML:#x" name="q">`},
+		{`<div><script>if (a < b) { x = "</p>" }</script></div>`, `<div hx-swap-oob="outerHTML:#x"><script>if (a < b) { x = "</p>" }</script></div>`},
+		{`text <p>a</p>`, `<div id="x" hx-swap-oob="outerHTML:#x">text <p>a</p></div>`},
+		{`<p>a</p><p>b</p>`, `<div id="x" hx-swap-oob="outerHTML:#x"><p>a</p><p>b</p></div>`},
+		{`<p>a</p> text`, `<div id="x" hx-swap-oob="outerHTML:#x"><p>a</p> text</div>`},
+		{`<!-- c --><p>a</p>`, `<div id="x" hx-swap-oob="outerHTML:#x"><!-- c --><p>a</p></div>`},
+		{`plain`, `<div id="x" hx-swap-oob="outerHTML:#x">plain</div>`},
+		{`<tr><td>a</td></tr>`, `<template><tr hx-swap-oob="outerHTML:#x"><td>a</td></tr></template>`},
+		{`<tr><td>a</td></tr><tr><td>b</td></tr>`, `<template><tbody id="x" hx-swap-oob="outerHTML:#x"><tr><td>a</td></tr><tr><td>b</td></tr></tbody></template>`},
+	}
+
+	for _, test := range tests {
+		stamped, err := oobFragment{target: "#x"}.stamp(template.HTML(test.output))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		equal(t, test.expected, string(stamped))
+	}
+
+	// the wrapper replaces the target, it can only take over an id
+	for _, target := range []string{"", ".x", "#list > li"} {
+		if _, err := (oobFragment{target: target}).stamp(`<p>a</p><p>b</p>`); err == nil {
+			t.Errorf("expected an error for several elements with target %q", target)
+		}
+	}
+
+	cells, err := oobFragment{target: "#row", swap: NewSwap().Style(SwapBeforeEnd)}.stamp(`<td>a</td>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	equal(t, `<template><tr hx-swap-oob="beforeend:#row"><td>a</td></tr></template>`, string(cells))
+}
+
+func TestRenderOOBFullPage(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h := New().NewHandler(w, r)
+	h.AddOOB("#counter", nil, newTestComponent("counter.html").AddData("Count", 3))
+
+	page := newTestComponent("page.html").AddData("Title", "Cart").Wrap(newTestComponent("index.html"), "Content")
+
+	_, err := h.Render(context.Background(), page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, `<html><main>Cart</main></html>`, w.Body.String())
+}
+
+func TestRenderVary(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w.Header().Set("Vary", "Accept-Encoding, hx-request")
+
+	_, err := New().NewHandler(w, r).Render(context.Background(), newTestComponent("page.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, "Accept-Encoding, hx-request,HX-Boosted,HX-History-Restore-Request", strings.Join(w.Header().Values("Vary"), ","))
+}
+
+func TestRenderVaryDisabled(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h := New()
+	h.SetVary(false)
+
+	_, err := h.NewHandler(w, r).Render(context.Background(), newTestComponent("page.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, "", w.Header().Get("Vary"))
+}
+
+func TestRenderTarget(t *testing.T) {
+	tests := map[string]string{
+		"":          `<form>go</form><ul><li>a</li><li>b</li></ul>`,
+		"results":   `<ul><li>a</li><li>b</li></ul>`,
+		"count":     `<span>2</span>`,
+		"unknown":   `<form>go</form><ul><li>a</li><li>b</li></ul>`,
+		"div#count": `<span>2</span>`,
+	}
+
+	for target, expected := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("HX-Request", "true")
+		r.Header.Set("HX-Target", target)
+
+		search := newTestComponent("search.html").
+			SetData(map[string]any{"Query": "go", "Results": []string{"a", "b"}}).
+			Target("#results", "results").
+			Target("count", "count")
+
+		_, err := New().NewHandler(w, r).Render(context.Background(), search)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		equal(t, expected, w.Body.String())
+		equal(t, "HX-Request,HX-Boosted,HX-History-Restore-Request,HX-Target", strings.Join(w.Header().Values("Vary"), ","))
+	}
+}
+
+func TestRenderFragment(t *testing.T) {
+	search := newTestComponent("search.html").SetData(map[string]any{"Query": "go", "Results": []string{"a"}})
+
+	output, err := search.RenderFragment(context.Background(), "count")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, `<span>1</span>`, string(output))
+
+	if _, err = search.RenderFragment(context.Background(), "missing"); err == nil {
+		t.Error("expected an error for an unknown fragment")
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h := New().NewHandler(w, r)
+	h.AddOOB("#counter", nil, newTestComponent("counter.html").AddData("Count", 1))
+
+	_, err = h.RenderFragment(context.Background(), search, "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, `<ul><li>a</li></ul>`, w.Body.String())
+}
+
+func TestRenderConcurrent(t *testing.T) {
+	layout := newTestComponent("index.html")
+	definition := newTestComponent("page.html").AddData("Title", "default").Wrap(layout, "Content")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			title := strconv.Itoa(i)
+			page := definition.Instance().AddData("Title", title)
+
+			_, err := New().NewHandler(w, r).Render(context.Background(), page)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			equal(t, "<html><main>"+title+"</main></html>", w.Body.String())
+		}(i)
+	}
+	wg.Wait()
+
+	output, err := definition.Render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, "<main>default</main>", string(output))
+}
+
+func TestRenderDataInjection(t *testing.T) {
+	sidebar := NewComponent("sidebar.html").FS(fstest.MapFS{
+		"sidebar.html": {Data: []byte(`<aside>{{ .Data.Title }} {{ .Global.User }} {{ .URL.Path }}</aside>`)},
+	})
+	layout := NewComponent("layout.html").FS(fstest.MapFS{
+		"layout.html": {Data: []byte(`<html>{{ .Data.Title }}{{ .Partials.Sidebar }}{{ .Partials.Content }}</html>`)},
+	}).With(sidebar, "Sidebar")
+
+	page := newTestComponent("page.html").
+		AddData("Title", "Home").
+		Wrap(layout, "Content")
+	layout.AddGlobalData("User", "jane")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/home", nil)
+
+	_, err := New().NewHandler(w, r).Render(context.Background(), page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, "<html>Home<aside>Home jane /home</aside><main>Home</main></html>", w.Body.String())
+}
+
+func TestTemplateCacheKey(t *testing.T) {
+	otherFS := fstest.MapFS{
+		"page.html": {Data: []byte(`<main>other {{ .Data.Title }}</main>`)},
+	}
+
+	// same first template from a different filesystem
+	first, err := newTestComponent("page.html").AddData("Title", "a").Render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := NewComponent("page.html").FS(otherFS).AddData("Title", "a").Render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, "<main>a</main>", string(first))
+	equal(t, "<main>other a</main>", string(second))
+
+	// same first template with different attached templates
+	attachFS := fstest.MapFS{
+		"main.html": {Data: []byte(`{{ template "part" . }}`)},
+		"a.html":    {Data: []byte(`{{ define "part" }}a{{ end }}`)},
+		"b.html":    {Data: []byte(`{{ define "part" }}b{{ end }}`)},
+	}
+
+	a, err := NewComponent("main.html").FS(attachFS).Attach("a.html").Render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewComponent("main.html").FS(attachFS).Attach("b.html").Render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, "a", string(a))
+	equal(t, "b", string(b))
+
+	// same function name with a different implementation
+	funcFS := fstest.MapFS{
+		"func.html": {Data: []byte(`{{ shout "Hi" }}`)},
+	}
+
+	upper, err := NewComponent("func.html").FS(funcFS).AddTemplateFunction("shout", strings.ToUpper).Render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lower, err := NewComponent("func.html").FS(funcFS).AddTemplateFunction("shout", strings.ToLower).Render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, "HI", string(upper))
+	equal(t, "hi", string(lower))
+
+	upperKey, _ := TemplateCacheKey(funcFS, []string{"func.html"}, template.FuncMap{"shout": strings.ToUpper})
+	lowerKey, _ := TemplateCacheKey(funcFS, []string{"func.html"}, template.FuncMap{"shout": strings.ToLower})
+	if upperKey == lowerKey {
+		t.Error("expected different functions to result in different cache keys")
+	}
+}
+
+type testTranslator struct {
+	locale string
+}
+
+func (tr testTranslator) T(s string) string {
+	return tr.locale + ":" + s
+}
+
+func testPrefix(p string) func(string) string {
+	return func(s string) string { return p + s }
+}
+
+func TestTemplateCacheKeyClosures(t *testing.T) {
+	funcFS := fstest.MapFS{
+		"t.html": {Data: []byte(`{{ t "hi" }}`)},
+	}
+
+	render := func(c *Component) string {
+		t.Helper()
+
+		output, err := c.FS(funcFS).Render(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(output)
+	}
+
+	// bound methods share the implementation of the method
+	equal(t, "en:hi", render(NewComponent("t.html").AddTemplateFunction("t", testTranslator{"en"}.T).(*Component)))
+	equal(t, "nl:hi", render(NewComponent("t.html").AddTemplateFunction("t", testTranslator{"nl"}.T).(*Component)))
+
+	// closures share the implementation of the function literal
+	equal(t, "Ahi", render(NewComponent("t.html").AddTemplateFunction("t", testPrefix("A")).(*Component)))
+	equal(t, "Bhi", render(NewComponent("t.html").AddTemplateFunction("t", testPrefix("B")).(*Component)))
+
+	if _, ok := TemplateCacheKey(funcFS, []string{"t.html"}, template.FuncMap{"t": testTranslator{"en"}.T}); ok {
+		t.Error("expected a method value not to be cacheable")
+	}
+
+	if _, ok := TemplateCacheKey(funcFS, []string{"t.html"}, template.FuncMap{"t": testPrefix("A")}); ok {
+		t.Error("expected a closure not to be cacheable")
+	}
+
+	// the function set key makes them cacheable, separated by the key
+	cache := NewTemplateCache()
+
+	h := New()
+	h.SetTemplateCache(cache)
+
+	for _, locale := range []string{"en", "nl", "en"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		page := NewComponent("t.html").FS(funcFS).FuncSetKey("locale="+locale).AddTemplateFunction("t", testTranslator{locale}.T)
+		if _, err := h.NewHandler(w, r).Render(context.Background(), page); err != nil {
+			t.Fatal(err)
+		}
+
+		equal(t, locale+":hi", w.Body.String())
+	}
+
+	key, _ := templateCacheKey(funcFS, []string{"t.html"}, template.FuncMap{"t": testTranslator{"en"}.T}, "locale=en")
+	if _, ok := cache.Load(key); !ok {
+		t.Error("expected the templates with a function set key to be cached")
+	}
+}
+
+func TestTemplateCacheKeyDefaultFuncs(t *testing.T) {
+	DefaultTemplateFuncs["up"] = func(s string) string { return strings.ToUpper(s) }
+	defer delete(DefaultTemplateFuncs, "up")
+
+	// closures of the default functions are shared by every component, they keep the templates cacheable
+	key, ok := TemplateCacheKey(testFS, []string{"page.html"}, template.FuncMap{"shout": strings.ToUpper})
+	if !ok {
+		t.Fatal("expected a closure in the default functions to be cacheable")
+	}
+
+	cache := NewTemplateCache()
+
+	h := New()
+	h.SetTemplateCache(cache)
+
+	if err := h.Precompile(newTestComponent("page.html").AddTemplateFunction("shout", strings.ToUpper)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.Load(key); !ok {
+		t.Error("expected the component to be precompiled")
+	}
+}
+
+func TestTemplateCacheUncachedWarning(t *testing.T) {
+	warnFS := fstest.MapFS{
+		"warn.html": {Data: []byte(`{{ t "hi" }}`)},
+	}
+
+	log := &testLogger{}
+	h := New()
+	h.SetLog(log)
+
+	for _, locale := range []string{"en", "nl"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		page := NewComponent("warn.html").FS(warnFS).AddTemplateFunction("t", testTranslator{locale}.T)
+		if _, err := h.NewHandler(w, r).Render(context.Background(), page); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the templates are reported once, not on every render
+	equalInt(t, 1, len(log.warnings))
+}
+
+func TestHTMXTemplateCache(t *testing.T) {
+	cache := NewTemplateCache()
+
+	h := New()
+	h.SetTemplateCache(cache)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	_, err := h.NewHandler(w, r).Render(context.Background(), newTestComponent("counter.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := TemplateCacheKey(testFS, []string{"counter.html"}, template.FuncMap{})
+	if _, ok := cache.Load(key); !ok {
+		t.Error("expected the template to be stored in the htmx template cache")
+	}
+
+	cache.Clear()
+	if _, ok := cache.Load(key); ok {
+		t.Error("expected the template cache to be cleared")
+	}
+}
+
+func TestPrecompile(t *testing.T) {
+	cache := NewTemplateCache()
+
+	h := New()
+	h.SetTemplateCache(cache)
+
+	page := newTestComponent("page.html").
+		With(newTestComponent("counter.html"), "Counter").
+		Wrap(newTestComponent("index.html"), "Content")
+
+	if err := h.Precompile(page); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"page.html", "counter.html", "index.html"} {
+		key, _ := TemplateCacheKey(testFS, []string{name}, template.FuncMap{})
+		if _, ok := cache.Load(key); !ok {
+			t.Errorf("expected %s to be precompiled", name)
+		}
+	}
+
+	brokenFS := fstest.MapFS{
+		"broken.html": {Data: []byte("<p>\n{{ .Data.Name }</p>")},
+		"layout.html": {Data: []byte(`{{ .Partials.Content }}`)},
+	}
+
+	broken := NewComponent("broken.html").FS(brokenFS).
+		With(NewComponent("layout.html").FS(brokenFS).Attach("missing.html"), "Missing").
+		Wrap(NewComponent().FS(brokenFS), "Content")
+
+	err := h.Precompile(broken)
+	if err == nil {
+		t.Fatal("expected an error for the broken components")
+	}
+
+	for _, expected := range []string{"broken.html:2", "missing.html", "no templates provided"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected the error to contain %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestRenderSlots(t *testing.T) {
+	slotFS := fstest.MapFS{
+		"base.html": {Data: []byte(`<title>{{ with .Slots.title }}{{ . }}{{ else }}Site{{ end }}</title>` +
+			`<aside>{{ with .Slots.sidebar }}{{ . }}{{ else }}default{{ end }}</aside>` +
+			`<footer>{{ .Slots.footer }}</footer>{{ .Partials.Body }}`)},
+		"section.html": {Data: []byte(`<section>{{ .Partials.Content }}</section>` +
+			`{{ define "title" }}Section{{ end }}{{ define "footer" }}section footer{{ end }}`)},
+		"page.html":  {Data: []byte(`<main>{{ .Data.Name }}</main>{{ define "title" }}{{ .Data.Name }}{{ end }}`)},
+		"links.html": {Data: []byte(`<a>{{ .Data.Name }}</a>`)},
+	}
+
+	base := NewComponent("base.html").FS(slotFS)
+	section := NewComponent("section.html").FS(slotFS).
+		Slot("title", "title").
+		Slot("footer", "footer").
+		Wrap(base, "Body")
+
+	page := NewComponent("page.html").FS(slotFS).
+		AddData("Name", "Shop").
+		Slot("title", "title").
+		SlotComponent("sidebar", NewComponent("links.html").FS(slotFS)).
+		Wrap(section, "Content")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if _, err := New().NewHandler(w, r).Render(context.Background(), page); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<title>Shop</title><aside><a>Shop</a></aside><footer>section footer</footer>` +
+		`<section><main>Shop</main></section>`
+
+	equal(t, expected, w.Body.String())
+
+	// the defaults of the wrapper are used when no slot is filled
+	w = httptest.NewRecorder()
+	if _, err := New().NewHandler(w, r).Render(context.Background(), newTestComponent("page.html").AddData("Title", "x").Wrap(base, "Body")); err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, `<title>Site</title><aside>default</aside><footer></footer><main>x</main>`, w.Body.String())
+}
+
+// slotPartialRenders counts the renders of the partial in TestRenderSlotsPartialsOnce
+var slotPartialRenders atomic.Int32
+
+func countSlotPartial() string {
+	slotPartialRenders.Add(1)
+	return "counted"
+}
+
+func TestRenderSlotsPartialsOnce(t *testing.T) {
+	slotFS := fstest.MapFS{
+		"base.html": {Data: []byte(`<header>{{ .Slots.title }}</header><aside>{{ .Slots.sidebar }}</aside>{{ .Partial "Body" }}`)},
+		"page.html": {Data: []byte(`<main>{{ .Partials.Nav }}</main>` +
+			`{{ define "title" }}{{ .Partials.Nav }}{{ end }}{{ define "sidebar" }}{{ .Partials.Nav }}{{ end }}`)},
+		"nav.html": {Data: []byte(`<nav>{{ count }}</nav>`)},
+	}
+
+	page := func() RenderableComponent {
+		return NewComponent("page.html").FS(slotFS).
+			With(NewComponent("nav.html").FS(slotFS).AddTemplateFunction("count", countSlotPartial), "Nav").
+			Slot("title", "title").
+			Slot("sidebar", "sidebar").
+			Wrap(NewComponent("base.html").FS(slotFS), "Body")
+	}
+
+	expected := `<header><nav>counted</nav></header><aside><nav>counted</nav></aside><main><nav>counted</nav></main>`
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	// the block slots share the partials rendered for the page
+	slotPartialRenders.Store(0)
+	w := httptest.NewRecorder()
+	if _, err := New().NewHandler(w, r).Render(context.Background(), page()); err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, expected, w.Body.String())
+	equalInt(t, 1, int(slotPartialRenders.Load()))
+
+	slotPartialRenders.Store(0)
+	w = httptest.NewRecorder()
+	if _, err := New().NewHandler(w, r).RenderStream(context.Background(), page()); err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, expected, w.Body.String())
+	equalInt(t, 1, int(slotPartialRenders.Load()))
+}
+
+func TestComponentURL(t *testing.T) {
+	urlFS := fstest.MapFS{
+		"page.html": {Data: []byte(`{{ with .URL }}{{ .Path }}{{ end }} {{ .Partials.Link }}`)},
+		"link.html": {Data: []byte(`{{ .URL.Path }}`)},
+	}
+
+	link := NewComponent("link.html").FS(urlFS)
+	link.SetURL(&url.URL{Path: "/link"})
+
+	page := NewComponent("page.html").FS(urlFS).With(link, "Link")
+
+	// a partial keeps its own url when the component rendering it has none
+	output, err := page.Render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	equal(t, " /link", string(output))
+
+	// the url of the component replaces the url of the partial
+	page.SetURL(&url.URL{Path: "/page"})
+
+	output, err = page.Render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	equal(t, "/page /page", string(output))
+
+	// Handler.Render uses the url of the request
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/request", nil)
+
+	if _, err = New().NewHandler(w, r).Render(context.Background(), page); err != nil {
+		t.Fatal(err)
+	}
+	equal(t, "/request /request", w.Body.String())
+}
@@ -0,0 +1,185 @@
+package htmx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	texttemplate "text/template"
+)
+
+type (
+	// RenderError is returned when rendering a component fails, it tells which component failed and where it is used.
+	RenderError struct {
+		// Templates are the templates of the component that failed
+		Templates []string
+		// Chain is the wrap chain of the rendered component, the first template of the component itself up to the outermost layout
+		Chain []string
+		// Partial is the key of the With partial or slot that failed, nested partials are separated by a dot
+		Partial string
+		// Err is the underlying error, a text/template ExecError when executing the template failed
+		Err error
+	}
+
+	// ErrorRenderer writes the response for a render that failed, it is set with HTMX.SetErrorRenderer.
+	ErrorRenderer func(ctx context.Context, h *Handler, err error) (int, error)
+)
+
+// Error returns the error message including the failing component and partial
+func (e *RenderError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("render ")
+	sb.WriteString(strings.Join(e.Templates, ", "))
+
+	if e.Partial != "" {
+		sb.WriteString(" (partial ")
+		sb.WriteString(e.Partial)
+		sb.WriteString(")")
+	}
+
+	if len(e.Chain) > 1 {
+		sb.WriteString(" in ")
+		sb.WriteString(strings.Join(e.Chain, " > "))
+	}
+
+	sb.WriteString(": ")
+	sb.WriteString(e.Err.Error())
+
+	return sb.String()
+}
+
+// Unwrap returns the underlying error
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// ExecError returns the text/template ExecError of the underlying error, the second value reports whether there is one
+func (e *RenderError) ExecError() (texttemplate.ExecError, bool) {
+	var execErr texttemplate.ExecError
+	ok := errors.As(e.Err, &execErr)
+
+	return execErr, ok
+}
+
+// renderError returns the error as a RenderError of the component, errors of nested components are kept as they are
+func renderError(templates []string, err error) error {
+	var re *RenderError
+	if errors.As(err, &re) {
+		return err
+	}
+
+	return &RenderError{Templates: templates, Err: err}
+}
+
+// partialError returns the error of the partial with the key as a RenderError, prefixing the key of nested partials
+func partialError(key string, templates []string, err error) error {
+	var re *RenderError
+	if !errors.As(err, &re) {
+		return &RenderError{Templates: templates, Partial: key, Err: err}
+	}
+
+	partial := *re
+	if partial.Partial == "" {
+		partial.Partial = key
+	} else {
+		partial.Partial = key + "." + partial.Partial
+	}
+
+	return &partial
+}
+
+// withChain adds the wrap chain of the rendered component to a RenderError
+func withChain(r RenderableComponent, err error) error {
+	var re *RenderError
+	if !errors.As(err, &re) {
+		return err
+	}
+
+	var chain []string
+	for c := r; c != nil; c = c.wrapper() {
+		if names := c.templateNames(); len(names) > 0 {
+			chain = append(chain, names[0])
+		}
+	}
+
+	chained := *re
+	chained.Chain = chain
+
+	return &chained
+}
+
+// errorClearedHeaders are the response headers set for the failed render that must not apply to the error response
+var errorClearedHeaders = []HxResponseKey{HXReselect, HXTrigger, HXTriggerAfterSettle, HXTriggerAfterSwap, HXPushUrl, HXReplaceUrl}
+
+// NewErrorRenderer returns an ErrorRenderer that renders the fragment component into the target for partial requests,
+// using HX-Retarget and HX-Reswap, and the page component for full page requests with a 500 status code.
+// Both components get the error as {{ .Data.Error }}, the page is wrapped in its layouts like Handler.Render does.
+// The status text is written when a component is nil, so no template details leak to the client.
+// The fragment is written with a 200 status code, since htmx does not swap error responses by default.
+// The reselect, trigger, push and replace url headers that were set before the render failed are removed.
+func NewErrorRenderer(target string, fragment, page RenderableComponent) ErrorRenderer {
+	return func(ctx context.Context, h *Handler, err error) (int, error) {
+		h.Discard()
+		h.clearHeaders(errorClearedHeaders...)
+
+		if h.RenderPartial() {
+			h.ReTarget(target)
+			h.ReSwapWithObject(NewSwap().Style(SwapInnerHTML))
+
+			if fragment == nil {
+				return h.WriteString(http.StatusText(http.StatusInternalServerError))
+			}
+
+			output, renderErr := fragment.render(ctx, renderInput{data: map[string]any{"Error": err}, url: h.r.URL, cache: h.cache, log: h.log})
+			if renderErr != nil {
+				return 0, fmt.Errorf("render error fragment: %w", renderErr)
+			}
+
+			return h.WriteHTML(output)
+		}
+
+		h.WriteHeader(http.StatusInternalServerError)
+
+		if page == nil {
+			return h.WriteString(http.StatusText(http.StatusInternalServerError))
+		}
+
+		n, renderErr := h.render(ctx, page.Instance().AddData("Error", err))
+		if renderErr != nil {
+			return n, fmt.Errorf("render error page: %w", renderErr)
+		}
+
+		return n, nil
+	}
+}
+
+// SetErrorRenderer sets the ErrorRenderer that writes the response when Handler.Render or Handler.RenderFragment fails.
+// The error is still returned by the handler, so it can be logged, but no other response should be written.
+func (h *HTMX) SetErrorRenderer(renderer ErrorRenderer) {
+	h.errorRenderer = renderer
+}
+
+// clearHeaders removes the response headers, together with the triggers that are collected for them
+func (h *Handler) clearHeaders(keys ...HxResponseKey) {
+	for _, key := range keys {
+		h.Header().Del(key.String())
+		delete(h.triggers, key)
+	}
+}
+
+// failRender annotates the error with the wrap chain of the component and writes it with the error renderer when one is set
+func (h *Handler) failRender(ctx context.Context, r RenderableComponent, err error) (int, error) {
+	err = withChain(r, err)
+
+	if h.errorRenderer == nil {
+		return 0, err
+	}
+
+	n, renderErr := h.errorRenderer(ctx, h, err)
+	if renderErr != nil {
+		return n, errors.Join(err, renderErr)
+	}
+
+	return n, err
+}
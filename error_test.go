@@ -0,0 +1,122 @@
+package htmx
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var errorFS = fstest.MapFS{
+	"layout.html": {Data: []byte(`<body>{{ .Partials.Content }}</body>`)},
+	"page.html":   {Data: []byte(`<main>{{ .Partials.Cart }}</main>`)},
+	"cart.html":   {Data: []byte(`<div>{{ .Partials.Total }}</div>`)},
+	"total.html":  {Data: []byte(`{{ index .Data.Items 3 }}`)},
+	"error.html":  {Data: []byte(`<p class="error">{{ .Data.Error }}</p>`)},
+	"500.html":    {Data: []byte(`<h1>Oops</h1>`)},
+}
+
+func brokenPage() RenderableComponent {
+	cart := NewComponent("cart.html").FS(errorFS).
+		With(NewComponent("total.html").FS(errorFS).AddData("Items", []int{1}), "Total")
+
+	return NewComponent("page.html").FS(errorFS).
+		With(cart, "Cart").
+		Wrap(NewComponent("layout.html").FS(errorFS), "Content")
+}
+
+func TestRenderError(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	_, err := New().NewHandler(w, r).Render(context.Background(), brokenPage())
+
+	var re *RenderError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected a RenderError, got %v", err)
+	}
+
+	equal(t, "total.html", strings.Join(re.Templates, ","))
+	equal(t, "page.html,layout.html", strings.Join(re.Chain, ","))
+	equal(t, "Cart.Total", re.Partial)
+
+	if _, ok := re.ExecError(); !ok {
+		t.Errorf("expected an exec error, got %v", re.Err)
+	}
+
+	equal(t, "", w.Body.String())
+}
+
+func TestErrorRenderer(t *testing.T) {
+	h := New()
+	h.SetErrorRenderer(NewErrorRenderer("#errors", NewComponent("error.html").FS(errorFS), NewComponent("500.html").FS(errorFS).Wrap(NewComponent("layout.html").FS(errorFS), "Content")))
+
+	// partial requests get the fragment in the error container
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("HX-Request", "true")
+
+	if _, err := h.NewHandler(w, r).Render(context.Background(), brokenPage()); err == nil {
+		t.Error("expected the render error to be returned")
+	}
+
+	equalInt(t, http.StatusOK, w.Code)
+	equal(t, "#errors", w.Header().Get(HXRetarget.String()))
+	equal(t, "innerHTML", w.Header().Get(HXReswap.String()))
+	if !strings.HasPrefix(w.Body.String(), `<p class="error">render total.html`) {
+		t.Errorf("expected the error fragment, got %s", w.Body.String())
+	}
+
+	// full page requests get the error page in its layout
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if _, err := h.NewHandler(w, r).Render(context.Background(), brokenPage()); err == nil {
+		t.Error("expected the render error to be returned")
+	}
+
+	equalInt(t, http.StatusInternalServerError, w.Code)
+	equal(t, `<body><h1>Oops</h1></body>`, w.Body.String())
+}
+
+func TestErrorRendererHeaders(t *testing.T) {
+	cache := NewTemplateCache()
+
+	h := New()
+	h.SetTemplateCache(cache)
+	h.SetErrorRenderer(NewErrorRenderer("#errors", NewComponent("error.html").FS(errorFS), nil))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("HX-Request", "true")
+
+	handler := h.NewHandler(w, r)
+	handler.ReSelect("#cart")
+	handler.PushURL("/cart")
+	handler.ReplaceURL("/cart")
+	handler.Trigger("saved")
+	handler.TriggerAfterSwap("saved")
+
+	if _, err := handler.Render(context.Background(), brokenPage()); err == nil {
+		t.Error("expected the render error to be returned")
+	}
+
+	for _, key := range errorClearedHeaders {
+		if value := w.Header().Get(key.String()); value != "" {
+			t.Errorf("expected %s to be removed, got %s", key, value)
+		}
+	}
+
+	// a trigger added after the failure does not bring back the removed ones
+	handler.Trigger("failed")
+	equal(t, "failed", w.Header().Get(HXTrigger.String()))
+
+	key, _ := TemplateCacheKey(errorFS, []string{"error.html"}, template.FuncMap{})
+	if _, ok := cache.Load(key); !ok {
+		t.Error("expected the error fragment to use the template cache of the htmx instance")
+	}
+}
@@ -1,8 +1,10 @@
 package htmx
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"html/template"
 	"net/http"
 )
@@ -14,6 +16,19 @@ type (
 		r        *http.Request
 		request  HxRequestHeader
 		response *HxResponseHeader
+		oob      []oobFragment
+		vary     bool
+		triggers map[HxResponseKey]*Trigger
+		cache    TemplateCache
+
+		// errorRenderer writes the response when rendering fails
+		errorRenderer ErrorRenderer
+
+		// buffered handlers collect the status code and body until Flush or Close is called
+		buffered    bool
+		buf         *bytes.Buffer
+		status      int
+		wroteHeader bool
 	}
 )
 
@@ -38,13 +53,19 @@ func (h *Handler) IsHxHistoryRestoreRequest() bool {
 }
 
 // RenderPartial returns true if the request is an HTMX request that is either boosted or a standard request,
-// provided it is not a history restore request.
+// provided it is not a history restore request. With htmx 4 the HX-Request-Type header decides.
 func (h *Handler) RenderPartial() bool {
-	return (h.request.HxRequest || h.request.HxBoosted) && !h.request.HxHistoryRestoreRequest
+	return h.request.RenderPartial()
 }
 
 // Write writes the data to the connection as part of an HTTP reply.
+// A buffered handler collects the data until Flush or Close is called.
 func (h *Handler) Write(data []byte) (n int, err error) {
+	if h.buffered {
+		return h.buf.Write(data)
+	}
+
+	h.wroteHeader = true
 	return h.w.Write(data)
 }
 
@@ -133,10 +154,86 @@ func (h *Handler) MustWriteJSON(data any) {
 }
 
 // WriteHeader sets the HTTP response header with the provided status code.
+// A buffered handler keeps the status code until Flush or Close is called, so it can still be changed.
 func (h *Handler) WriteHeader(code int) {
+	if h.buffered && !h.wroteHeader {
+		h.status = code
+		return
+	}
+
+	if h.wroteHeader {
+		h.log.Warn("status code set after the response was written", "status", code)
+		return
+	}
+
+	h.wroteHeader = true
 	h.w.WriteHeader(code)
 }
 
+// Flush writes the buffered status code, headers and body to the connection and flushes it to the client.
+func (h *Handler) Flush() {
+	if _, err := h.commit(); err != nil {
+		h.log.Warn(err.Error())
+	}
+
+	if flusher, ok := h.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close writes the buffered status code, headers and body to the connection.
+func (h *Handler) Close() error {
+	_, err := h.commit()
+	return err
+}
+
+// Discard drops the buffered status code and body, this allows writing an error response after a render failed half-way.
+func (h *Handler) Discard() {
+	if !h.buffered {
+		return
+	}
+
+	if h.wroteHeader {
+		h.log.Warn("cannot discard a response that has already been written")
+		return
+	}
+
+	h.status = 0
+	h.buf.Reset()
+}
+
+// commit writes the buffered status code and body to the connection
+func (h *Handler) commit() (int, error) {
+	if !h.buffered {
+		return 0, nil
+	}
+
+	if !h.wroteHeader {
+		h.wroteHeader = true
+		if h.status != 0 {
+			h.w.WriteHeader(h.status)
+		}
+	}
+
+	if h.buf.Len() == 0 {
+		return 0, nil
+	}
+
+	n, err := h.w.Write(h.buf.Bytes())
+	h.buf.Reset()
+
+	return n, err
+}
+
+// setHeader sets the htmx response header, headers set after the response has been written are logged since they are lost
+func (h *Handler) setHeader(k HxResponseKey, val string) {
+	if h.wroteHeader {
+		h.log.Warn("header set after the response was written", "header", k.String())
+	}
+
+	h.response.Set(k, val)
+}
+
 // StopPolling sets the response status to 286, which will stop htmx from polling
 func (h *Handler) StopPolling() {
 	h.WriteHeader(StatusStopPolling)
@@ -166,88 +263,187 @@ func (h *Handler) Location(li *LocationInput) error {
 		return err
 	}
 
-	h.response.Set(HXLocation, string(payload))
+	h.setHeader(HXLocation, string(payload))
 	return nil
 }
 
 // PushURL pushes a new url into the history stack.
 // https://htmx.org/headers/hx-push-url/
 func (h *Handler) PushURL(val string) {
-	h.response.Set(HXPushUrl, val)
+	h.setHeader(HXPushUrl, val)
 }
 
 // Redirect can be used to do a client-side redirect to a new location
 func (h *Handler) Redirect(val string) {
-	h.response.Set(HXRedirect, val)
+	h.setHeader(HXRedirect, val)
 }
 
 // Refresh if set to true the client side will do a full refresh of the page
 func (h *Handler) Refresh(val bool) {
-	h.response.Set(HXRefresh, HxBoolToStr(val))
+	h.setHeader(HXRefresh, HxBoolToStr(val))
 }
 
 // ReplaceURL allows you to replace the current URL in the browser location history.
 // https://htmx.org/headers/hx-replace-url/
 func (h *Handler) ReplaceURL(val string) {
-	h.response.Set(HXReplaceUrl, val)
+	h.setHeader(HXReplaceUrl, val)
 }
 
 // ReSwap allows you to specify how the response will be swapped. See hx-swap for possible values
 // https://htmx.org/attributes/hx-swap/
 func (h *Handler) ReSwap(val string) {
-	h.response.Set(HXReswap, val)
+	h.setHeader(HXReswap, val)
 }
 
 // ReSwapWithObject allows you to specify how the response will be swapped. See hx-swap for possible values
+// An invalid swap is logged and the header is not set, use TryReSwapWithObject to get the error instead.
 // https://htmx.org/attributes/hx-swap/
 func (h *Handler) ReSwapWithObject(s *Swap) {
+	if err := h.TryReSwapWithObject(s); err != nil {
+		h.log.Warn(err.Error())
+	}
+}
+
+// TryReSwapWithObject allows you to specify how the response will be swapped. See hx-swap for possible values
+// The header is not set when the swap is invalid, the error is returned instead.
+// https://htmx.org/attributes/hx-swap/
+func (h *Handler) TryReSwapWithObject(s *Swap) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
 	h.ReSwap(s.String())
+	return nil
 }
 
 // ReTarget a CSS selector that updates the target of the content update to a different element on the page
 func (h *Handler) ReTarget(val string) {
-	h.response.Set(HXRetarget, val)
+	h.setHeader(HXRetarget, val)
 }
 
 // ReSelect a CSS selector that allows you to choose which part of the response is used to be swapped in. Overrides an existing hx-select on the triggering element
 func (h *Handler) ReSelect(val string) {
-	h.response.Set(HXReselect, val)
+	h.setHeader(HXReselect, val)
 }
 
 // Trigger triggers events as soon as the response is received.
+// Events are merged with the events that were added earlier during the request.
 // https://htmx.org/headers/hx-trigger/
 func (h *Handler) Trigger(val string) {
-	h.response.Set(HXTrigger, val)
+	h.addTriggerString(HXTrigger, val)
 }
 
 // TriggerWithObject triggers events as soon as the response is received.
+// Events are merged with the events that were added earlier during the request.
+// An invalid Trigger set is logged and not added, use TryTriggerWithObject to get the error instead.
 // https://htmx.org/headers/hx-trigger/
 func (h *Handler) TriggerWithObject(t *Trigger) {
-	h.Trigger(t.String())
+	if err := h.TryTriggerWithObject(t); err != nil {
+		h.log.Warn(err.Error())
+	}
+}
+
+// TryTriggerWithObject triggers events as soon as the response is received.
+// Events are merged with the events that were added earlier during the request, an invalid Trigger set is not added
+// and the error is returned instead.
+// https://htmx.org/headers/hx-trigger/
+func (h *Handler) TryTriggerWithObject(t *Trigger) error {
+	return h.addTrigger(HXTrigger, t)
 }
 
 // TriggerAfterSettle trigger events after the settling step.
+// Events are merged with the events that were added earlier during the request.
 // https://htmx.org/headers/hx-trigger/
 func (h *Handler) TriggerAfterSettle(val string) {
-	h.response.Set(HXTriggerAfterSettle, val)
+	h.addTriggerString(HXTriggerAfterSettle, val)
 }
 
 // TriggerAfterSettleWithObject trigger events after the settling step.
+// Events are merged with the events that were added earlier during the request.
+// An invalid Trigger set is logged and not added, use TryTriggerAfterSettleWithObject to get the error instead.
 // https://htmx.org/headers/hx-trigger/
 func (h *Handler) TriggerAfterSettleWithObject(t *Trigger) {
-	h.TriggerAfterSettle(t.String())
+	if err := h.TryTriggerAfterSettleWithObject(t); err != nil {
+		h.log.Warn(err.Error())
+	}
+}
+
+// TryTriggerAfterSettleWithObject trigger events after the settling step.
+// Events are merged with the events that were added earlier during the request, an invalid Trigger set is not added
+// and the error is returned instead.
+// https://htmx.org/headers/hx-trigger/
+func (h *Handler) TryTriggerAfterSettleWithObject(t *Trigger) error {
+	return h.addTrigger(HXTriggerAfterSettle, t)
 }
 
 // TriggerAfterSwap trigger events after the swap step.
+// Events are merged with the events that were added earlier during the request.
 // https://htmx.org/headers/hx-trigger/
 func (h *Handler) TriggerAfterSwap(val string) {
-	h.response.Set(HXTriggerAfterSwap, val)
+	h.addTriggerString(HXTriggerAfterSwap, val)
 }
 
 // TriggerAfterSwapWithObject trigger events after the swap step.
+// Events are merged with the events that were added earlier during the request.
+// An invalid Trigger set is logged and not added, use TryTriggerAfterSwapWithObject to get the error instead.
 // https://htmx.org/headers/hx-trigger/
 func (h *Handler) TriggerAfterSwapWithObject(t *Trigger) {
-	h.TriggerAfterSwap(t.String())
+	if err := h.TryTriggerAfterSwapWithObject(t); err != nil {
+		h.log.Warn(err.Error())
+	}
+}
+
+// TryTriggerAfterSwapWithObject trigger events after the swap step.
+// Events are merged with the events that were added earlier during the request, an invalid Trigger set is not added
+// and the error is returned instead.
+// https://htmx.org/headers/hx-trigger/
+func (h *Handler) TryTriggerAfterSwapWithObject(t *Trigger) error {
+	return h.addTrigger(HXTriggerAfterSwap, t)
+}
+
+// addTriggerString parses the header value and merges the events into the trigger of the given phase
+func (h *Handler) addTriggerString(phase HxResponseKey, val string) {
+	t, err := ParseTrigger(val)
+	if err != nil {
+		h.log.Warn(err.Error(), "header", phase.String())
+		return
+	}
+
+	if err = h.addTrigger(phase, t); err != nil {
+		h.log.Warn(err.Error(), "header", phase.String())
+	}
+}
+
+// addTrigger validates and merges the events into the trigger of the given phase and updates the response header
+func (h *Handler) addTrigger(phase HxResponseKey, t *Trigger) error {
+	if t == nil {
+		return nil
+	}
+
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	if h.triggers == nil {
+		h.triggers = make(map[HxResponseKey]*Trigger)
+	}
+
+	merged, ok := h.triggers[phase]
+	if !ok {
+		merged = NewTrigger()
+		h.triggers[phase] = merged
+	}
+
+	merged.Merge(t)
+
+	h.setHeader(phase, merged.String())
+	return nil
+}
+
+// Vary adds the given request headers to the Vary response header.
+// Use this when the response depends on htmx request headers outside of Render.
+func (h *Handler) Vary(keys ...HxRequestHeaderKey) {
+	Vary(h.Header(), keys...)
 }
 
 // Request returns the HxHeaders from the request
@@ -261,21 +457,59 @@ func (h *Handler) ResponseHeader(header HxResponseKey) string {
 }
 
 // Render renders the given renderer with the given context and writes the output to the response writer
+// For partial requests where the HX-Target matches a target of the component, only the matching block is rendered.
+// A failed render returns a RenderError, which is written by the error renderer of the htmx instance when one is set.
 func (h *Handler) Render(ctx context.Context, r RenderableComponent) (int, error) {
-	r.SetURL(h.r.URL)
+	n, err := h.render(ctx, r)
+	if err != nil {
+		return h.failRender(ctx, r, err)
+	}
 
-	output, err := r.Render(ctx)
+	return n, nil
+}
+
+// render renders the component with its out-of-band fragments for partial requests, or wrapped in its layouts otherwise
+func (h *Handler) render(ctx context.Context, r RenderableComponent) (int, error) {
+	partial := h.RenderPartial()
+	targets := r.targetBlocks()
+
+	var block string
+	if partial {
+		block = targets[h.request.TargetID()]
+	}
+
+	// the With partials are rendered once for the component, its slots and its wrappers
+	rendered := make(renderedPartials)
+
+	output, err := r.render(ctx, renderInput{url: h.r.URL, block: block, cache: h.cache, log: h.log, rendered: rendered})
 	if err != nil {
 		return 0, err
 	}
 
-	// If it's a partial render, return the output directly
-	if h.RenderPartial() {
-		return h.WriteHTML(output)
+	// The output depends on the htmx request headers, make sure caches know about it
+	if h.vary {
+		h.Vary(h.request.Version.VaryHeaders()...)
+
+		if len(targets) > 0 {
+			h.Vary(HxRequestHeaderTarget)
+		}
+	}
+
+	h.refreshTemplates(partial)
+
+	// If it's a partial render, return the output directly together with the out-of-band fragments
+	if partial {
+		oob, err := h.renderOOB(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		return h.WriteHTML(output + oob)
 	}
 
 	// Recursively wrap the output if the component is wrapped
-	output, err = h.wrapOutput(ctx, r, output)
+	model, hasModel := r.model()
+	output, err = h.wrapOutput(ctx, r, output, renderInput{data: r.data(), model: model, hasModel: hasModel, rendered: rendered})
 	if err != nil {
 		return 0, err
 	}
@@ -284,24 +518,96 @@ func (h *Handler) Render(ctx context.Context, r RenderableComponent) (int, error
 	return h.WriteHTML(output)
 }
 
-// wrapOutput recursively wraps the output in its parent components
-func (h *Handler) wrapOutput(ctx context.Context, r RenderableComponent, output template.HTML) (template.HTML, error) {
+// RenderFragment renders a single named block of the given renderer and writes the output to the response writer.
+// Fragments are never wrapped, out-of-band fragments are appended for partial requests.
+func (h *Handler) RenderFragment(ctx context.Context, r RenderableComponent, name string) (int, error) {
+	if name == "" {
+		return 0, errors.New("no fragment name provided for rendering")
+	}
+
+	output, err := r.render(ctx, renderInput{url: h.r.URL, block: name, cache: h.cache, log: h.log})
+	if err != nil {
+		return h.failRender(ctx, r, err)
+	}
+
+	partial := h.RenderPartial()
+	if partial {
+		oob, err := h.renderOOB(ctx)
+		if err != nil {
+			return h.failRender(ctx, r, err)
+		}
+
+		output += oob
+	}
+
+	h.refreshTemplates(partial)
+
+	if h.vary && len(h.oob) > 0 {
+		h.Vary(h.request.Version.VaryHeaders()...)
+	}
+
+	return h.WriteHTML(output)
+}
+
+// wrapOutput recursively wraps the output in its parent components.
+// The data and the model of the child are handed to the parent, without modifying the parent component.
+// The slots of the child are added to the slots filled further down, where the slots of the deeper children take precedence.
+func (h *Handler) wrapOutput(ctx context.Context, r RenderableComponent, output template.HTML, in renderInput) (template.HTML, error) {
 	if !r.isWrapped() {
 		// Base case: no more wrapping
 		return output, nil
 	}
 
+	own, err := r.renderSlots(ctx, renderInput{data: in.data, url: h.r.URL, cache: h.cache, log: h.log, model: in.model, hasModel: in.hasModel, rendered: in.rendered})
+	if err != nil {
+		return "", err
+	}
+
+	slots := mergeSlots(own, in.slots)
+
 	parent := r.wrapper()
-	parent.SetURL(h.r.URL)
-	parent.injectData(r.data())
-	parent.addPartial(r.target(), output)
 
 	// Render the parent component
-	parentOutput, err := parent.Render(ctx)
+	parentOutput, err := parent.render(ctx, renderInput{
+		data:     in.data,
+		partials: map[string]any{r.target(): output},
+		url:      h.r.URL,
+		cache:    h.cache,
+		log:      h.log,
+		slots:    slots,
+		model:    in.model,
+		hasModel: in.hasModel,
+		rendered: in.rendered,
+	})
 	if err != nil {
 		return "", err
 	}
 
+	model, hasModel := parent.model()
+	if !hasModel {
+		model, hasModel = in.model, in.hasModel
+	}
+
 	// Recursively wrap the parent output if the parent is also wrapped
-	return h.wrapOutput(ctx, parent, parentOutput)
+	return h.wrapOutput(ctx, parent, parentOutput, renderInput{
+		data:     mergeMap(parent.data(), in.data),
+		slots:    slots,
+		model:    model,
+		hasModel: hasModel,
+		rendered: in.rendered,
+	})
+}
+
+// mergeSlots returns a new map with the slots of both maps, the slots filled further down the wrap chain take precedence
+func mergeSlots(own, inherited map[string]template.HTML) map[string]template.HTML {
+	merged := make(map[string]template.HTML, len(own)+len(inherited))
+	for name, value := range own {
+		merged[name] = value
+	}
+
+	for name, value := range inherited {
+		merged[name] = value
+	}
+
+	return merged
 }
@@ -4,10 +4,13 @@
 package htmx
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"github.com/donseba/go-htmx/sse"
 	"log/slog"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 )
@@ -18,6 +21,15 @@ var (
 
 	DefaultNotificationKey   = "showMessage"
 	DefaultSSEWorkerPoolSize = 5
+
+	// DefaultHxVersion is the htmx protocol version used to read the request headers
+	DefaultHxVersion = HxVersion2
+
+	// ExtensionSwapStyles are the swap styles added by htmx extensions that are accepted by Swap.Validate
+	ExtensionSwapStyles []SwapStyle
+
+	// DefaultVary adds the htmx request headers to the Vary response header when Render decides between a partial and a full page
+	DefaultVary = true
 )
 
 // this is the default sseManager implementation which is created to handle the server-sent events.
@@ -29,14 +41,22 @@ type (
 	}
 
 	HTMX struct {
-		log Logger
+		log     Logger
+		version HxVersion
+		vary    bool
+		cache   TemplateCache
+
+		errorRenderer ErrorRenderer
 	}
 )
 
 // New returns a new htmx instance.
 func New() *HTMX {
 	return &HTMX{
-		log: slog.Default().WithGroup("htmx"),
+		log:     slog.Default().WithGroup("htmx"),
+		version: DefaultHxVersion,
+		vary:    DefaultVary,
+		cache:   DefaultTemplateCache,
 	}
 }
 
@@ -45,6 +65,21 @@ func (h *HTMX) SetLog(log Logger) {
 	h.log = log
 }
 
+// SetVersion sets the htmx protocol version of the javascript library, this decides which request headers are read.
+func (h *HTMX) SetVersion(version HxVersion) {
+	h.version = version
+}
+
+// SetVary enables or disables the automatic Vary response header of Handler.Render.
+func (h *HTMX) SetVary(enabled bool) {
+	h.vary = enabled
+}
+
+// SetTemplateCache sets the cache for parsed templates that is used by the handlers of the htmx instance.
+func (h *HTMX) SetTemplateCache(cache TemplateCache) {
+	h.cache = cache
+}
+
 // NewHandler returns a new htmx handler.
 func (h *HTMX) NewHandler(w http.ResponseWriter, r *http.Request) *Handler {
 	return &Handler{
@@ -53,7 +88,76 @@ func (h *HTMX) NewHandler(w http.ResponseWriter, r *http.Request) *Handler {
 		request:  h.HxHeader(r),
 		response: h.HxResponseHeader(w.Header()),
 		log:      h.log,
+		vary:     h.vary,
+		cache:    h.cache,
+
+		errorRenderer: h.errorRenderer,
+	}
+}
+
+// NewBufferedHandler returns a new htmx handler that buffers the status code and body until Flush or Close is called.
+// This allows setting headers after rendering has started and changing the status code when a render fails half-way.
+func (h *HTMX) NewBufferedHandler(w http.ResponseWriter, r *http.Request) *Handler {
+	handler := h.NewHandler(w, r)
+	handler.buffered = true
+	handler.buf = new(bytes.Buffer)
+
+	return handler
+}
+
+// Precompile parses the templates of the given components, their wrappers and their partials into the template cache.
+// Call it at startup to find broken templates and missing attached files before the first request does,
+// all errors are reported at once.
+// Components with template functions that are closures or method values are checked, but not cached,
+// unless they set a FuncSetKey.
+func (h *HTMX) Precompile(components ...RenderableComponent) error {
+	var errs []error
+	seen := make(map[RenderableComponent]bool)
+
+	var walk func(c RenderableComponent)
+	walk = func(c RenderableComponent) {
+		if c == nil || seen[c] {
+			return
+		}
+		seen[c] = true
+
+		if err := c.precompile(h.cache, h.log); err != nil {
+			errs = append(errs, err)
+		}
+
+		walk(c.wrapper())
+
+		// walk the partials and slots in a stable order, so the errors are reported in the same order every time
+		for _, partial := range sortedComponents(c.partials()) {
+			walk(partial)
+		}
+
+		for _, slot := range sortedComponents(c.slotComponents()) {
+			walk(slot)
+		}
 	}
+
+	for _, c := range components {
+		walk(c)
+	}
+
+	return errors.Join(errs...)
+}
+
+// sortedComponents returns the components ordered by their key
+func sortedComponents(components map[string]RenderableComponent) []RenderableComponent {
+	keys := make([]string, 0, len(components))
+	for key := range components {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sorted := make([]RenderableComponent, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, components[key])
+	}
+
+	return sorted
 }
 
 // NewSSE creates a new sse manager with the specified worker pool size.
@@ -68,20 +172,40 @@ func (h *HTMX) NewSSE(workerPoolSize int) error {
 
 // SSEHandler handles the server-sent events. this is a shortcut and is not the preferred way to handle sse.
 func (h *HTMX) SSEHandler(w http.ResponseWriter, r *http.Request, cl sse.Listener) {
+	defaultSSEManager().Handle(w, r, cl)
+}
+
+// SSESend sends a message to all connected clients.
+func (h *HTMX) SSESend(message sse.Envelope) {
+	defaultSSEManager().Send(message)
+}
+
+// SSESendTo sends a message to the connected client with the given id.
+func (h *HTMX) SSESendTo(clientID string, message sse.Envelope) {
+	defaultSSEManager().SendTo(clientID, message)
+}
+
+// SSEPublish sends a message to the connected clients that are subscribed to the topic.
+func (h *HTMX) SSEPublish(topic string, message sse.Envelope) {
+	defaultSSEManager().Publish(topic, message)
+}
+
+// SSEShutdown shuts the default sse manager down, see sse.Manager.Shutdown.
+func (h *HTMX) SSEShutdown(ctx context.Context) error {
 	if sseManager == nil {
-		sseManager = sse.NewManager(DefaultSSEWorkerPoolSize)
+		return nil
 	}
 
-	sseManager.Handle(w, r, cl)
+	return sseManager.Shutdown(ctx)
 }
 
-// SSESend sends a message to all connected clients.
-func (h *HTMX) SSESend(message sse.Envelope) {
+// defaultSSEManager returns the default sse manager, it is created with the DefaultSSEWorkerPoolSize when it does not exist yet
+func defaultSSEManager() sse.Manager {
 	if sseManager == nil {
 		sseManager = sse.NewManager(DefaultSSEWorkerPoolSize)
 	}
 
-	sseManager.Send(message)
+	return sseManager
 }
 
 // IsHxRequest returns true if the request is a htmx request.
@@ -102,7 +226,7 @@ func IsHxHistoryRestoreRequest(r *http.Request) bool {
 // RenderPartial returns true if the request is an HTMX request that is either boosted or a hx request,
 // provided it is not a history restore request.
 func RenderPartial(r *http.Request) bool {
-	return (IsHxRequest(r) || IsHxBoosted(r)) && !IsHxHistoryRestoreRequest(r)
+	return HxRequestHeaderFromRequest(r).RenderPartial()
 }
 
 // HxStrToBool converts a string to a boolean value.
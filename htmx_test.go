@@ -228,6 +228,53 @@ func TestSwap(t *testing.T) {
 	equal(t, "innerHTML scroll:top settle:1s", resp.Header.Get(HXReswap.String()))
 }
 
+func TestBufferedHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	handler := New().NewBufferedHandler(w, r)
+	handler.WriteHeader(http.StatusOK)
+	handler.JustWriteString("half a template")
+
+	// something went wrong, replace the response
+	handler.Discard()
+	handler.WriteHeader(http.StatusUnprocessableEntity)
+	handler.JustWriteString("error")
+	handler.ReTarget(reTarget)
+
+	equal(t, "", w.Body.String())
+
+	if err := handler.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	equalInt(t, http.StatusUnprocessableEntity, w.Code)
+	equal(t, "error", w.Body.String())
+	equal(t, reTarget, w.Header().Get(HXRetarget.String()))
+}
+
+func TestHandlerLateHeader(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	log := &testLogger{}
+	h := New()
+	h.SetLog(log)
+
+	handler := h.NewHandler(w, r)
+	handler.JustWriteString("hi")
+	handler.PushURL(pushURL)
+
+	equalInt(t, 1, len(log.warnings))
+
+	buffered := h.NewBufferedHandler(httptest.NewRecorder(), r)
+	buffered.JustWriteString("hi")
+	buffered.PushURL(pushURL)
+	buffered.Flush()
+
+	equalInt(t, 1, len(log.warnings))
+}
+
 func TestHxStrToBool(t *testing.T) {
 	equalBool(t, true, HxStrToBool("true"))
 	equalBool(t, false, HxStrToBool("false"))
@@ -250,6 +297,14 @@ func (dummyWriter) Header() http.Header {
 }
 func (dummyWriter) WriteHeader(int) {}
 
+type testLogger struct {
+	warnings []string
+}
+
+func (l *testLogger) Warn(msg string, _ ...any) {
+	l.warnings = append(l.warnings, msg)
+}
+
 func equalBool(t *testing.T, expected, actual bool) {
 	if expected != actual {
 		t.Errorf("expected %t, got %t", expected, actual)
@@ -22,3 +22,15 @@ func MiddleWare(next http.Handler) http.Handler {
 	}
 	return http.HandlerFunc(fn)
 }
+
+// Vary is a middleware that adds the htmx request headers to the Vary response header.
+// Use it for handlers that return a partial or a full page without going through htmx.Handler.Render,
+// so caches and CDNs never serve a fragment to a full page navigation.
+func Vary(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		htmx.Vary(w.Header(), htmx.DefaultHxVersion.VaryHeaders()...)
+
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
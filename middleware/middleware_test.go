@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVary(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	Vary(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hi"))
+	})).ServeHTTP(w, r)
+
+	expected := "HX-Request,HX-Boosted,HX-History-Restore-Request"
+	if got := strings.Join(w.Header().Values("Vary"), ","); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
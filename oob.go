@@ -0,0 +1,252 @@
+package htmx
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+const (
+	// hxSwapOOBAttribute is the attribute htmx uses to detect out-of-band swaps
+	hxSwapOOBAttribute = "hx-swap-oob"
+)
+
+type oobFragment struct {
+	target    string
+	swap      *Swap
+	component RenderableComponent
+}
+
+// AddOOB registers a component that is rendered as an out-of-band swap after the main output of Render.
+// The target is the css selector of the element to update, the swap style is taken from the given Swap (outerHTML when nil).
+// Out-of-band fragments are only written for partial requests, full page renders skip them.
+// https://htmx.org/attributes/hx-swap-oob/
+func (h *Handler) AddOOB(target string, swap *Swap, component RenderableComponent) {
+	h.oob = append(h.oob, oobFragment{
+		target:    target,
+		swap:      swap,
+		component: component,
+	})
+}
+
+// renderOOB renders all the registered out-of-band fragments
+func (h *Handler) renderOOB(ctx context.Context) (template.HTML, error) {
+	var sb strings.Builder
+
+	for _, fragment := range h.oob {
+		output, err := fragment.component.render(ctx, renderInput{url: h.r.URL, cache: h.cache, log: h.log})
+		if err != nil {
+			return "", err
+		}
+
+		stamped, err := fragment.stamp(output)
+		if err != nil {
+			return "", err
+		}
+
+		sb.WriteString(string(stamped))
+	}
+
+	return template.HTML(sb.String()), nil
+}
+
+// value returns the hx-swap-oob attribute value for the fragment
+func (f oobFragment) value() string {
+	style := SwapOuterHTML
+	if f.swap != nil {
+		style = f.swap.style
+	}
+
+	if f.target == "" {
+		return style.String()
+	}
+
+	return style.String() + ":" + f.target
+}
+
+// stamp adds the hx-swap-oob attribute to the rendered output.
+// An outerHTML swap replaces the target with the element itself, so the attribute is placed on the root element.
+// Output that is not a single element is wrapped in an element carrying the attribute and the id of the target,
+// so later swaps still find the target. Any other swap style uses the children of the oob element, so the output
+// is wrapped in an element carrying the attribute.
+// Table content is wrapped in a template, with the attribute on an element that can hold it, like a tbody for rows,
+// since the html parser drops table rows and cells outside of their parent.
+func (f oobFragment) stamp(output template.HTML) (template.HTML, error) {
+	attr := hxSwapOOBAttribute + `="` + template.HTMLEscapeString(f.value()) + `"`
+	container := oobContainer(string(output))
+
+	var stamped template.HTML
+	if f.swap != nil && f.swap.style != SwapOuterHTML {
+		stamped = template.HTML("<"+container+" "+attr+">") + output + template.HTML("</"+container+">")
+	} else if i := singleRootTagEnd(string(output)); i > 0 {
+		stamped = output[:i] + template.HTML(" "+attr) + output[i:]
+	} else {
+		id, ok := targetID(f.target)
+		if !ok {
+			return "", fmt.Errorf("out-of-band fragment for %q must be a single element, or target an element by its id", f.target)
+		}
+
+		stamped = template.HTML("<"+container+` id="`+template.HTMLEscapeString(id)+`" `+attr+">") + output + template.HTML("</"+container+">")
+	}
+
+	if container != "div" {
+		return "<template>" + stamped + "</template>", nil
+	}
+
+	return stamped, nil
+}
+
+// oobContainer returns the element that can hold the output, based on its first element
+func oobContainer(html string) string {
+	html = strings.TrimLeft(html, " \t\r\n")
+	if len(html) < 2 || html[0] != '<' || !isASCIILetter(html[1]) {
+		return "div"
+	}
+
+	end := 1
+	for end < len(html) && !strings.ContainsRune(" \t\r\n/>", rune(html[end])) {
+		end++
+	}
+
+	if container, ok := tableContainers[strings.ToLower(html[1:end])]; ok {
+		return container
+	}
+
+	return "div"
+}
+
+// targetID returns the id of a target selector like #counter, the second value is false for any other selector
+func targetID(target string) (string, bool) {
+	id, ok := strings.CutPrefix(target, "#")
+	if !ok || id == "" || strings.ContainsAny(id, " \t\r\n.#[]:>+~,()*") {
+		return "", false
+	}
+
+	return id, true
+}
+
+// singleRootTagEnd returns the position right after the tag name of the root element when the html is a single
+// element, surrounded by whitespace at most, or -1 otherwise. Elements that rely on implied end tags are not
+// recognized as a single element.
+func singleRootTagEnd(html string) int {
+	start := len(html) - len(strings.TrimLeft(html, " \t\r\n"))
+	if start+1 >= len(html) || html[start] != '<' || !isASCIILetter(html[start+1]) {
+		return -1
+	}
+
+	rootEnd := start + 1
+	for rootEnd < len(html) && !strings.ContainsRune(" \t\r\n/>", rune(html[rootEnd])) {
+		rootEnd++
+	}
+
+	depth := 0
+	for i := start; i < len(html); {
+		if depth == 0 && i > start {
+			// the root element is closed, only whitespace may follow
+			if strings.TrimSpace(html[i:]) != "" {
+				return -1
+			}
+
+			return rootEnd
+		}
+
+		switch {
+		case strings.HasPrefix(html[i:], "<!--"):
+			end := strings.Index(html[i+4:], "-->")
+			if end < 0 {
+				return -1
+			}
+			i += 4 + end + 3
+
+		case strings.HasPrefix(html[i:], "</"):
+			end := strings.IndexByte(html[i:], '>')
+			if end < 0 {
+				return -1
+			}
+			depth--
+			i += end + 1
+
+		case html[i] == '<' && i+1 < len(html) && isASCIILetter(html[i+1]):
+			nameEnd := i + 1
+			for nameEnd < len(html) && !strings.ContainsRune(" \t\r\n/>", rune(html[nameEnd])) {
+				nameEnd++
+			}
+			name := strings.ToLower(html[i+1 : nameEnd])
+
+			end := tagEnd(html, nameEnd)
+			if end < 0 {
+				return -1
+			}
+			i = end
+
+			// a void root element is closed right away
+			if voidElements[name] || html[end-2] == '/' {
+				continue
+			}
+
+			depth++
+
+			// the content of raw text elements is not parsed as html
+			if rawTextElements[name] {
+				closing := strings.Index(strings.ToLower(html[i:]), "</"+name)
+				if closing < 0 {
+					return -1
+				}
+				i += closing
+			}
+
+		default:
+			i++
+		}
+	}
+
+	if depth != 0 {
+		return -1
+	}
+
+	return rootEnd
+}
+
+// tagEnd returns the position right after the closing > of the tag, skipping quoted attribute values, or -1
+func tagEnd(html string, i int) int {
+	var quote byte
+	for ; i < len(html); i++ {
+		switch c := html[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+var (
+	// tableContainers are the parents of the table elements the html parser drops anywhere else
+	tableContainers = map[string]string{
+		"tr": "tbody", "td": "tr", "th": "tr",
+		"thead": "table", "tbody": "table", "tfoot": "table", "caption": "table", "colgroup": "table",
+		"col": "colgroup",
+	}
+
+	// voidElements have no closing tag
+	voidElements = map[string]bool{
+		"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+		"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+	}
+
+	// rawTextElements contain text up to their closing tag
+	rawTextElements = map[string]bool{
+		"script": true, "style": true, "textarea": true, "title": true,
+	}
+)
+
+func isASCIILetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
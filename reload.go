@@ -0,0 +1,318 @@
+package htmx
+
+import (
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/donseba/go-htmx/sse"
+)
+
+var (
+	// DefaultTemplateReloadInterval is the interval at which the TemplateReloader checks the templates for changes
+	DefaultTemplateReloadInterval = time.Second
+
+	// TemplateGenerationCookie is the cookie that holds the template generation a full page was rendered with
+	TemplateGenerationCookie = "htmx-template-generation"
+
+	// DefaultTemplateReloadEvent is the sse event that is sent to the browsers when the templates changed
+	DefaultTemplateReloadEvent = "htmx-template-reload"
+)
+
+type (
+	// TemplateReloader is a TemplateCache for development that keeps the parsed templates cached,
+	// but drops them as soon as one of the template files changes on disk.
+	// Changes are detected by polling the modification time of the files, filesystems without
+	// modification times (like embed.FS) are never reloaded.
+	TemplateReloader struct {
+		templates  sync.Map
+		interval   time.Duration
+		generation atomic.Uint64
+		refresh    bool
+
+		mu       sync.Mutex
+		watched  map[string]watchedTemplates
+		onChange []func()
+		stop     chan struct{}
+	}
+
+	// watchedTemplates are the template files of a cache entry together with their modification times
+	watchedTemplates struct {
+		fsys     fs.FS
+		patterns []string
+		modTimes map[string]time.Time
+	}
+
+	// templateWatcher is implemented by template caches that want to know which files a cache entry was parsed from
+	templateWatcher interface {
+		Watch(key string, fsys fs.FS, patterns []string)
+	}
+)
+
+// NewTemplateReloader returns a new TemplateReloader that checks the templates for changes at the given interval.
+// The DefaultTemplateReloadInterval is used when the interval is not positive. Call Start to begin polling.
+func NewTemplateReloader(interval time.Duration) *TemplateReloader {
+	if interval <= 0 {
+		interval = DefaultTemplateReloadInterval
+	}
+
+	return &TemplateReloader{
+		interval: interval,
+		watched:  make(map[string]watchedTemplates),
+	}
+}
+
+// Load returns the cached template for the key
+func (r *TemplateReloader) Load(key string) (*template.Template, bool) {
+	tmpl, ok := r.templates.Load(key)
+	if !ok {
+		return nil, false
+	}
+
+	return tmpl.(*template.Template), true
+}
+
+// Store caches the template for the key when its files are watched.
+// Templates without a watch entry are not cached, either Watch was never called for them or Check dropped the entry
+// because the files changed while they were parsed. Caching them would keep them from ever being reloaded.
+func (r *TemplateReloader) Store(key string, tmpl *template.Template) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.watched[key]; !ok {
+		return
+	}
+
+	r.templates.Store(key, tmpl)
+}
+
+// Clear removes all cached templates and stops watching their files
+func (r *TemplateReloader) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.templates.Range(func(key, _ any) bool {
+		r.templates.Delete(key)
+		return true
+	})
+	r.watched = make(map[string]watchedTemplates)
+}
+
+// Watch records the modification times of the files matching the template patterns of the cache entry.
+// It is called by the component right before the templates are parsed.
+func (r *TemplateReloader) Watch(key string, fsys fs.FS, patterns []string) {
+	watched := watchedTemplates{
+		fsys:     fsys,
+		patterns: patterns,
+		modTimes: modTimes(fsys, patterns),
+	}
+
+	r.mu.Lock()
+	r.watched[key] = watched
+	r.mu.Unlock()
+}
+
+// OnChange registers a function that is called after changed templates have been dropped from the cache
+func (r *TemplateReloader) OnChange(fn func()) *TemplateReloader {
+	r.mu.Lock()
+	r.onChange = append(r.onChange, fn)
+	r.mu.Unlock()
+
+	return r
+}
+
+// Refresh makes the handlers send a HX-Refresh header to partial requests of pages that were rendered
+// with templates that have changed since. The generation of the templates is kept in the TemplateGenerationCookie.
+func (r *TemplateReloader) Refresh(enabled bool) *TemplateReloader {
+	r.mu.Lock()
+	r.refresh = enabled
+	r.mu.Unlock()
+
+	return r
+}
+
+// Notify sends an sse message with the event name and the new generation to all clients of the manager when the templates changed.
+// The DefaultTemplateReloadEvent is used when the event is empty.
+func (r *TemplateReloader) Notify(manager sse.Manager, event string) *TemplateReloader {
+	if event == "" {
+		event = DefaultTemplateReloadEvent
+	}
+
+	return r.OnChange(func() {
+		manager.Send(sse.NewMessage(strconv.FormatUint(r.Generation(), 10)).WithEvent(event))
+	})
+}
+
+// Generation returns the number of times the templates changed since the reloader was created
+func (r *TemplateReloader) Generation() uint64 {
+	return r.generation.Load()
+}
+
+// Start begins polling the template files for changes in the background, it does nothing when already started
+func (r *TemplateReloader) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	r.stop = stop
+
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.Check()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops polling the template files for changes
+func (r *TemplateReloader) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
+
+// Check compares the modification times of the watched template files with the recorded ones.
+// Cache entries with changed files are dropped, and the generation is increased when anything changed.
+// It reports whether any template changed.
+func (r *TemplateReloader) Check() bool {
+	r.mu.Lock()
+	watched := make(map[string]watchedTemplates, len(r.watched))
+	for key, value := range r.watched {
+		watched[key] = value
+	}
+	r.mu.Unlock()
+
+	// stat the files without holding the lock, so parsing is not blocked by a slow filesystem
+	var changed []string
+	for key, value := range watched {
+		if !equalModTimes(value.modTimes, modTimes(value.fsys, value.patterns)) {
+			changed = append(changed, key)
+		}
+	}
+
+	if len(changed) == 0 {
+		return false
+	}
+
+	r.mu.Lock()
+	for _, key := range changed {
+		delete(r.watched, key)
+		r.templates.Delete(key)
+	}
+	callbacks := r.onChange[:len(r.onChange):len(r.onChange)]
+	r.mu.Unlock()
+
+	r.generation.Add(1)
+
+	for _, fn := range callbacks {
+		fn()
+	}
+
+	return true
+}
+
+// refreshEnabled returns true when the handlers should refresh pages rendered with outdated templates
+func (r *TemplateReloader) refreshEnabled() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.refresh
+}
+
+// modTimes returns the modification times of the files matching the patterns.
+// Missing files and patterns without matches are recorded with a zero time, so their creation is noticed.
+func modTimes(fsys fs.FS, patterns []string) map[string]time.Time {
+	times := make(map[string]time.Time)
+
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil || len(matches) == 0 {
+			times[pattern] = time.Time{}
+			continue
+		}
+
+		for _, name := range matches {
+			info, err := fs.Stat(fsys, name)
+			if err != nil {
+				times[name] = time.Time{}
+				continue
+			}
+
+			times[name] = info.ModTime()
+		}
+	}
+
+	return times
+}
+
+// equalModTimes returns true when both sets of modification times are the same
+func equalModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for name, t := range a {
+		if other, ok := b[name]; !ok || !other.Equal(t) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// EnableTemplateReload replaces the template cache of the htmx instance with a TemplateReloader
+// that checks the templates for changes at the given interval, and starts it.
+// This is meant for development, the reloader is returned to configure Refresh, Notify or OnChange.
+func (h *HTMX) EnableTemplateReload(interval time.Duration) *TemplateReloader {
+	reloader := NewTemplateReloader(interval)
+	reloader.Start()
+
+	h.SetTemplateCache(reloader)
+
+	return reloader
+}
+
+// refreshTemplates keeps the browser in sync with the templates when the cache is a TemplateReloader with Refresh enabled.
+// Full pages store the template generation in a cookie, partial requests of a page with an older generation get a HX-Refresh.
+func (h *Handler) refreshTemplates(partial bool) {
+	reloader, ok := h.cache.(*TemplateReloader)
+	if !ok || !reloader.refreshEnabled() {
+		return
+	}
+
+	generation := strconv.FormatUint(reloader.Generation(), 10)
+
+	if !partial {
+		http.SetCookie(h.w, &http.Cookie{
+			Name:     TemplateGenerationCookie,
+			Value:    generation,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return
+	}
+
+	if cookie, err := h.r.Cookie(TemplateGenerationCookie); err == nil && cookie.Value != generation {
+		h.Refresh(true)
+	}
+}
@@ -0,0 +1,141 @@
+package htmx
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTemplate(t *testing.T, dir, name, content string, modTime time.Time) {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemplateReloader(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	writeTemplate(t, dir, "page.html", `<p>{{ .Data.Name }}</p>`, start)
+
+	reloader := NewTemplateReloader(time.Millisecond)
+
+	changes := 0
+	reloader.OnChange(func() { changes++ })
+
+	h := New()
+	h.SetTemplateCache(reloader)
+
+	render := func() string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		_, err := h.NewHandler(w, r).Render(context.Background(), NewComponent("page.html").FS(os.DirFS(dir)).AddData("Name", "htmx"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return w.Body.String()
+	}
+
+	equal(t, "<p>htmx</p>", render())
+	equalBool(t, false, reloader.Check())
+
+	writeTemplate(t, dir, "page.html", `<h1>{{ .Data.Name }}</h1>`, start.Add(time.Minute))
+
+	equalBool(t, true, reloader.Check())
+	equalInt(t, 1, changes)
+	equalInt(t, 1, int(reloader.Generation()))
+	equal(t, "<h1>htmx</h1>", render())
+
+	// the reparsed templates are watched again
+	equalBool(t, false, reloader.Check())
+}
+
+func TestTemplateReloaderStoreAfterChange(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	writeTemplate(t, dir, "page.html", `<p>page</p>`, start)
+
+	reloader := NewTemplateReloader(time.Millisecond)
+	fsys := os.DirFS(dir)
+	tmpl := template.Must(template.New("page.html").Parse(`<p>page</p>`))
+
+	// the files change after Watch and before Store, the parsed templates may be outdated
+	reloader.Watch("page", fsys, []string{"page.html"})
+	writeTemplate(t, dir, "page.html", `<h1>page</h1>`, start.Add(time.Minute))
+	equalBool(t, true, reloader.Check())
+
+	reloader.Store("page", tmpl)
+
+	_, ok := reloader.Load("page")
+	equalBool(t, false, ok)
+
+	// templates without a watch entry are not cached
+	reloader.Store("unwatched", tmpl)
+
+	_, ok = reloader.Load("unwatched")
+	equalBool(t, false, ok)
+
+	// watching again caches the templates until the next change
+	reloader.Watch("page", fsys, []string{"page.html"})
+	reloader.Store("page", tmpl)
+
+	_, ok = reloader.Load("page")
+	equalBool(t, true, ok)
+}
+
+func TestTemplateReloaderRefresh(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	writeTemplate(t, dir, "page.html", `<p>page</p>`, start)
+
+	h := New()
+	reloader := h.EnableTemplateReload(time.Hour).Refresh(true)
+	defer reloader.Stop()
+
+	page := NewComponent("page.html").FS(os.DirFS(dir))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if _, err := h.NewHandler(w, r).Render(context.Background(), page); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != TemplateGenerationCookie {
+		t.Fatalf("expected the %s cookie, got %v", TemplateGenerationCookie, cookies)
+	}
+
+	partial := func() string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("HX-Request", "true")
+		r.AddCookie(cookies[0])
+
+		if _, err := h.NewHandler(w, r).Render(context.Background(), page); err != nil {
+			t.Fatal(err)
+		}
+
+		return w.Header().Get(HXRefresh.String())
+	}
+
+	equal(t, "", partial())
+
+	writeTemplate(t, dir, "page.html", `<p>changed</p>`, start.Add(time.Minute))
+	reloader.Check()
+
+	equal(t, "true", partial())
+}
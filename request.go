@@ -2,6 +2,8 @@ package htmx
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 const (
@@ -16,45 +18,216 @@ const (
 	HxRequestHeaderTarget                HxRequestHeaderKey = "HX-Target"
 	HxRequestHeaderTriggerName           HxRequestHeaderKey = "HX-Trigger-Name"
 	HxRequestHeaderTrigger               HxRequestHeaderKey = "HX-Trigger"
+	HxRequestHeaderRequestType           HxRequestHeaderKey = "HX-Request-Type" // htmx 4: "partial" or "full"
+	HxRequestHeaderSource                HxRequestHeaderKey = "HX-Source"       // htmx 4: replaces HX-Trigger and HX-Trigger-Name
+
+	// HxRequestTypePartial is the HX-Request-Type value of a request that expects a partial response
+	HxRequestTypePartial = "partial"
+	// HxRequestTypeFull is the HX-Request-Type value of a request that expects a full page response
+	HxRequestTypeFull = "full"
+
+	// HxRequestHeaderURIAutoEncodedSuffix is appended to a header name by htmx when the value had to be URI encoded
+	HxRequestHeaderURIAutoEncodedSuffix = "-URI-AutoEncoded"
 )
 
 type (
 	HxRequestHeaderKey string
 
+	// HxVersion is the htmx protocol version that is used to interpret the request headers
+	HxVersion int
+
 	HxRequestHeader struct {
 		HxBoosted               bool
 		HxCurrentURL            string
 		HxHistoryRestoreRequest bool
 		HxPrompt                string
 		HxRequest               bool
+		HxRequestType           string
+		HxSource                string
 		HxTarget                string
 		HxTriggerName           string
 		HxTrigger               string
+		Version                 HxVersion
 	}
 )
 
-func HxRequestHeaderFromRequest(r *http.Request) HxRequestHeader {
-	return HxRequestHeader{
-		HxBoosted:               HxStrToBool(r.Header.Get(HxRequestHeaderBoosted.String())),
-		HxCurrentURL:            r.Header.Get(HxRequestHeaderCurrentURL.String()),
-		HxHistoryRestoreRequest: HxStrToBool(r.Header.Get(HxRequestHeaderHistoryRestoreRequest.String())),
-		HxPrompt:                r.Header.Get(HxRequestHeaderPrompt.String()),
-		HxRequest:               HxStrToBool(r.Header.Get(HxRequestHeaderRequest.String())),
-		HxTarget:                r.Header.Get(HxRequestHeaderTarget.String()),
-		HxTriggerName:           r.Header.Get(HxRequestHeaderTriggerName.String()),
-		HxTrigger:               r.Header.Get(HxRequestHeaderTrigger.String()),
+const (
+	HxVersion1 HxVersion = 1
+	HxVersion2 HxVersion = 2
+	HxVersion4 HxVersion = 4
+)
+
+// RequestHeaders returns the request headers that are sent by the given htmx version
+func (v HxVersion) RequestHeaders() []HxRequestHeaderKey {
+	if v >= HxVersion4 {
+		return []HxRequestHeaderKey{
+			HxRequestHeaderBoosted,
+			HxRequestHeaderCurrentURL,
+			HxRequestHeaderHistoryRestoreRequest,
+			HxRequestHeaderPrompt,
+			HxRequestHeaderRequest,
+			HxRequestHeaderRequestType,
+			HxRequestHeaderSource,
+			HxRequestHeaderTarget,
+		}
+	}
+
+	return []HxRequestHeaderKey{
+		HxRequestHeaderBoosted,
+		HxRequestHeaderCurrentURL,
+		HxRequestHeaderHistoryRestoreRequest,
+		HxRequestHeaderPrompt,
+		HxRequestHeaderRequest,
+		HxRequestHeaderTarget,
+		HxRequestHeaderTriggerName,
+		HxRequestHeaderTrigger,
 	}
 }
 
+// VaryHeaders returns the request headers the partial render decision of the given htmx version depends on
+func (v HxVersion) VaryHeaders() []HxRequestHeaderKey {
+	keys := []HxRequestHeaderKey{
+		HxRequestHeaderRequest,
+		HxRequestHeaderBoosted,
+		HxRequestHeaderHistoryRestoreRequest,
+	}
+
+	if v >= HxVersion4 {
+		keys = append(keys, HxRequestHeaderRequestType)
+	}
+
+	return keys
+}
+
+// HxRequestHeaderFromRequest reads the htmx headers from the request, URI encoded values are decoded.
+// The optional version selects the htmx header set, DefaultHxVersion is used when it is omitted.
+func HxRequestHeaderFromRequest(r *http.Request, version ...HxVersion) HxRequestHeader {
+	hxh := HxRequestHeader{
+		Version: DefaultHxVersion,
+	}
+
+	if len(version) > 0 {
+		hxh.Version = version[0]
+	}
+
+	for _, key := range hxh.Version.RequestHeaders() {
+		switch key {
+		case HxRequestHeaderBoosted:
+			hxh.HxBoosted = HxStrToBool(r.Header.Get(key.String()))
+		case HxRequestHeaderCurrentURL:
+			hxh.HxCurrentURL = hxHeaderValue(r, key)
+		case HxRequestHeaderHistoryRestoreRequest:
+			hxh.HxHistoryRestoreRequest = HxStrToBool(r.Header.Get(key.String()))
+		case HxRequestHeaderPrompt:
+			hxh.HxPrompt = hxHeaderValue(r, key)
+		case HxRequestHeaderRequest:
+			hxh.HxRequest = HxStrToBool(r.Header.Get(key.String()))
+		case HxRequestHeaderRequestType:
+			hxh.HxRequestType = r.Header.Get(key.String())
+		case HxRequestHeaderSource:
+			hxh.HxSource = hxHeaderValue(r, key)
+		case HxRequestHeaderTarget:
+			hxh.HxTarget = hxHeaderValue(r, key)
+		case HxRequestHeaderTriggerName:
+			hxh.HxTriggerName = hxHeaderValue(r, key)
+		case HxRequestHeaderTrigger:
+			hxh.HxTrigger = hxHeaderValue(r, key)
+		}
+	}
+
+	return hxh
+}
+
+// RenderPartial returns true if the request expects a partial response.
+// htmx 4 tells this explicitly with HX-Request-Type, older versions send HX-Request or HX-Boosted.
+// A history restore request always expects the full page.
+func (h HxRequestHeader) RenderPartial() bool {
+	if h.HxHistoryRestoreRequest {
+		return false
+	}
+
+	if h.Version >= HxVersion4 && h.HxRequestType != "" {
+		return h.HxRequestType == HxRequestTypePartial
+	}
+
+	return h.HxRequest || h.HxBoosted
+}
+
+// TargetID returns the id of the target element, htmx 4 sends the target as "tagName#id".
+func (h HxRequestHeader) TargetID() string {
+	if i := strings.LastIndexByte(h.HxTarget, '#'); i >= 0 {
+		return h.HxTarget[i+1:]
+	}
+
+	return h.HxTarget
+}
+
+// hxHeaderValue returns the value of the header, decoded when htmx had to URI encode it.
+// htmx falls back to encodeURIComponent for values that are not valid header values (for example non-ASCII prompts)
+// and marks this by sending an additional "<header>-URI-AutoEncoded: true" header.
+func hxHeaderValue(r *http.Request, key HxRequestHeaderKey) string {
+	val := r.Header.Get(key.String())
+
+	if !HxStrToBool(r.Header.Get(key.String() + HxRequestHeaderURIAutoEncodedSuffix)) {
+		return val
+	}
+
+	decoded, err := url.PathUnescape(val)
+	if err != nil {
+		return val
+	}
+
+	return decoded
+}
+
+// CurrentURL returns the parsed url of the page the user is on, nil when the header is missing or invalid.
+func (h HxRequestHeader) CurrentURL() *url.URL {
+	if h.HxCurrentURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(h.HxCurrentURL)
+	if err != nil {
+		return nil
+	}
+
+	return u
+}
+
+// CurrentPath returns the path of the page the user is on.
+func (h HxRequestHeader) CurrentPath() string {
+	u := h.CurrentURL()
+	if u == nil {
+		return ""
+	}
+
+	return u.Path
+}
+
+// CurrentQuery returns the query values of the page the user is on, the values are empty when there is no current url.
+func (h HxRequestHeader) CurrentQuery() url.Values {
+	u := h.CurrentURL()
+	if u == nil {
+		return url.Values{}
+	}
+
+	return u.Query()
+}
+
+// CurrentQueryParam returns the first value of the query parameter of the page the user is on.
+func (h HxRequestHeader) CurrentQueryParam(key string) string {
+	return h.CurrentQuery().Get(key)
+}
+
 func (h *HTMX) HxHeader(r *http.Request) HxRequestHeader {
 	header := r.Context().Value(ContextRequestHeader)
 
-	if val, ok := header.(HxRequestHeader); ok {
+	if val, ok := header.(HxRequestHeader); ok && val.Version == h.version {
 		return val
 	}
 
 	// if the header is not found from the middleware, try and populate it from the request
-	return HxRequestHeaderFromRequest(r)
+	return HxRequestHeaderFromRequest(r, h.version)
 }
 
 func (x HxRequestHeaderKey) String() string {
@@ -0,0 +1,87 @@
+package htmx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHxRequestHeaderCurrentURL(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("HX-Current-URL", "http://example.com/products?page=2&sort=name")
+
+	hxh := HxRequestHeaderFromRequest(r)
+
+	u := hxh.CurrentURL()
+	if u == nil {
+		t.Fatal("expected current url to be parsed")
+	}
+
+	equal(t, "example.com", u.Host)
+	equal(t, "/products", hxh.CurrentPath())
+	equal(t, "2", hxh.CurrentQueryParam("page"))
+	equal(t, "name", hxh.CurrentQuery().Get("sort"))
+}
+
+func TestHxRequestHeaderCurrentURLMissing(t *testing.T) {
+	hxh := HxRequestHeaderFromRequest(httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if hxh.CurrentURL() != nil {
+		t.Error("expected current url to be nil")
+	}
+
+	equal(t, "", hxh.CurrentPath())
+	equal(t, "", hxh.CurrentQueryParam("page"))
+}
+
+func TestHxRequestHeaderURIAutoEncoded(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("HX-Prompt", "caf%C3%A9%20au%20lait")
+	r.Header.Set("HX-Prompt-URI-AutoEncoded", "true")
+	r.Header.Set("HX-Current-URL", "http://example.com/caf%C3%A9")
+	r.Header.Set("HX-Target", "caf%C3%A9")
+
+	hxh := HxRequestHeaderFromRequest(r)
+
+	equal(t, "café au lait", hxh.HxPrompt)
+	equal(t, "http://example.com/caf%C3%A9", hxh.HxCurrentURL)
+	equal(t, "caf%C3%A9", hxh.HxTarget)
+	equal(t, "/café", hxh.CurrentPath())
+}
+
+func TestHxRequestHeaderVersion4(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("HX-Request", "true")
+	r.Header.Set("HX-Request-Type", "full")
+	r.Header.Set("HX-Source", "button#save")
+	r.Header.Set("HX-Target", "div#results")
+	r.Header.Set("HX-Trigger", "save")
+
+	hxh := HxRequestHeaderFromRequest(r, HxVersion4)
+
+	equal(t, "button#save", hxh.HxSource)
+	equal(t, "", hxh.HxTrigger)
+	equal(t, "results", hxh.TargetID())
+	equalBool(t, false, hxh.RenderPartial())
+
+	r.Header.Set("HX-Request-Type", "partial")
+	equalBool(t, true, HxRequestHeaderFromRequest(r, HxVersion4).RenderPartial())
+
+	// older versions ignore the htmx 4 headers
+	hxh = HxRequestHeaderFromRequest(r, HxVersion2)
+	equal(t, "", hxh.HxSource)
+	equal(t, "save", hxh.HxTrigger)
+	equalBool(t, true, hxh.RenderPartial())
+}
+
+func TestHTMXSetVersion(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("HX-Request", "true")
+	r.Header.Set("HX-Request-Type", "full")
+
+	h := New()
+	equalBool(t, true, h.NewHandler(httptest.NewRecorder(), r).RenderPartial())
+
+	h.SetVersion(HxVersion4)
+	equalBool(t, false, h.NewHandler(httptest.NewRecorder(), r).RenderPartial())
+}
@@ -2,6 +2,7 @@ package htmx
 
 import (
 	"net/http"
+	"strings"
 )
 
 type (
@@ -43,3 +44,27 @@ func (h *HxResponseHeader) Set(k HxResponseKey, val string) {
 func (h *HxResponseHeader) Get(k HxResponseKey) string {
 	return h.headers.Get(k.String())
 }
+
+// Vary adds the request headers to the Vary response header so caches keep the partial and the full page apart.
+// Headers that are already listed are skipped.
+func Vary(header http.Header, keys ...HxRequestHeaderKey) {
+	present := make(map[string]bool)
+	for _, line := range header.Values("Vary") {
+		for _, val := range strings.Split(line, ",") {
+			present[strings.ToLower(strings.TrimSpace(val))] = true
+		}
+	}
+
+	if present["*"] {
+		return
+	}
+
+	for _, key := range keys {
+		if present[strings.ToLower(key.String())] {
+			continue
+		}
+
+		present[strings.ToLower(key.String())] = true
+		header.Add("Vary", key.String())
+	}
+}
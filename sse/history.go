@@ -0,0 +1,78 @@
+package sse
+
+import "sync"
+
+// Record is a message in the History, together with the id the manager assigned and the clients it was sent to.
+type Record struct {
+	ID       uint64
+	Message  Envelope
+	ClientID string // ClientID is the single client the message was sent to, empty for broadcasts and topics.
+	Topic    string // Topic is the topic the message was published to, empty for broadcasts and single clients.
+}
+
+// matches returns true if the client is a recipient of the message
+func (r Record) matches(client Listener) bool {
+	switch {
+	case r.ClientID != "":
+		return client.ID() == r.ClientID
+	case r.Topic != "":
+		subscriber, ok := client.(Subscriber)
+		return ok && subscriber.Subscribed(r.Topic)
+	default:
+		return true
+	}
+}
+
+// ringHistory is a bounded, thread-safe History that keeps the most recent messages
+type ringHistory struct {
+	mu      sync.RWMutex
+	records []Record
+	start   int
+	size    int
+}
+
+// NewHistory returns a History that keeps the last size messages.
+func NewHistory(size int) History {
+	if size < 0 {
+		size = 0
+	}
+
+	return &ringHistory{
+		records: make([]Record, size),
+	}
+}
+
+// Add adds a message to the history, the oldest message is dropped when the history is full.
+func (h *ringHistory) Add(record Record) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.records) == 0 {
+		return
+	}
+
+	if h.size < len(h.records) {
+		h.records[(h.start+h.size)%len(h.records)] = record
+		h.size++
+		return
+	}
+
+	h.records[h.start] = record
+	h.start = (h.start + 1) % len(h.records)
+}
+
+// Since returns the messages after the id, oldest first.
+func (h *ringHistory) Since(id uint64) []Record {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var records []Record
+	for i := 0; i < h.size; i++ {
+		record := h.records[(h.start+i)%len(h.records)]
+		if record.ID > id {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
@@ -0,0 +1,151 @@
+package sse
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ShutdownEvent is the event name of the final message that is sent to the clients when the manager shuts down
+	ShutdownEvent = "shutdown"
+)
+
+// OnConnect calls the function when a client connects, after it is registered.
+func OnConnect(fn func(cl Listener)) Option {
+	return func(manager *broadcastManager) {
+		manager.onConnect = fn
+	}
+}
+
+// OnDisconnect calls the function when the connection of a client ends, after it is unregistered.
+// It is not called for a connection that ends after the client reconnected with the same id.
+func OnDisconnect(fn func(cl Listener)) Option {
+	return func(manager *broadcastManager) {
+		manager.onDisconnect = fn
+	}
+}
+
+// WithShutdownMessage sets the final message that is sent to the clients when the manager shuts down.
+func WithShutdownMessage(message Envelope) Option {
+	return func(manager *broadcastManager) {
+		manager.shutdown = message
+	}
+}
+
+// Shutdown stops accepting messages, delivers the messages that are already queued, sends the final message
+// to every connected client and ends their connections. It waits for the connections to end until the context is done,
+// the connections are ended even when the context is done before the queued messages are delivered.
+// Calling Shutdown again waits for the connections that are still open.
+func (manager *broadcastManager) Shutdown(ctx context.Context) error {
+	manager.mu.Lock()
+	if !manager.closed {
+		manager.closed = true
+		for _, queue := range manager.queues {
+			close(queue)
+		}
+	}
+	manager.mu.Unlock()
+
+	// the workers deliver the queued messages before they stop
+	err := wait(ctx, &manager.workers)
+
+	manager.closeDone.Do(func() {
+		close(manager.done)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return wait(ctx, &manager.conns)
+}
+
+// RegisterOnShutdown shuts the manager down when the server shuts down, so the open connections do not keep
+// http.Server.Shutdown waiting. The manager gets the timeout to close the connections.
+func RegisterOnShutdown(server *http.Server, manager Manager, timeout time.Duration) {
+	server.RegisterOnShutdown(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		_ = manager.Shutdown(ctx)
+	})
+}
+
+// connect registers the client, it returns false when the manager is shut down
+func (manager *broadcastManager) connect(cl Listener) bool {
+	manager.mu.RLock()
+	if manager.closed {
+		manager.mu.RUnlock()
+		return false
+	}
+
+	manager.conns.Add(1)
+	manager.register(cl)
+	manager.mu.RUnlock()
+
+	// the hook is called without holding the lock, so it can send messages
+	if manager.onConnect != nil {
+		manager.onConnect(cl)
+	}
+
+	return true
+}
+
+// disconnect unregisters the client when its connection ends. The hook is not called when the client already
+// reconnected, the new connection owns the registration then.
+func (manager *broadcastManager) disconnect(cl Listener) {
+	if manager.unregister(cl) && manager.onDisconnect != nil {
+		manager.onDisconnect(cl)
+	}
+
+	manager.conns.Done()
+}
+
+// drain writes the messages that are queued for the client
+func (manager *broadcastManager) drain(conn *connection, cl Listener, replayed uint64) {
+	for {
+		select {
+		case msg, ok := <-cl.Chan():
+			if !ok {
+				return
+			}
+
+			if e, ok := msg.(*event); ok && e.id <= replayed {
+				continue
+			}
+
+			if err := conn.write(msg); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// shutdownMessage returns the final message for the clients
+func (manager *broadcastManager) shutdownMessage() Envelope {
+	if manager.shutdown != nil {
+		return manager.shutdown
+	}
+
+	return NewMessage("").WithEvent(ShutdownEvent)
+}
+
+// wait waits for the wait group until the context is done
+func wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
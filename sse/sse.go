@@ -1,13 +1,35 @@
 package sse
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// LastEventIDHeader is the header a reconnecting client sends with the id of the last message it received
+	LastEventIDHeader = "Last-Event-ID"
+
+	// HeartbeatComment is the comment that is written to keep idle connections open
+	HeartbeatComment = "heartbeat"
+)
+
+var (
+	// DefaultHistorySize is the number of messages the default History of a manager keeps
+	DefaultHistorySize = 10
+
+	// DefaultReconnectDelay is the delay a client is told to wait before reconnecting when its connection reaches
+	// the maximum lifetime and no positive delay is configured
+	DefaultReconnectDelay = time.Second
+)
+
 type (
 	// Listener defines the interface for the receiving end.
 	Listener interface {
@@ -20,39 +42,91 @@ type (
 		String() string // Represent the envelope contents as a string for transmission.
 	}
 
+	// Subscriber is implemented by listeners that can subscribe to topics, only subscribers receive published messages.
+	Subscriber interface {
+		Subscribe(topics ...string)
+		Unsubscribe(topics ...string)
+		Subscribed(topic string) bool
+	}
+
 	// Manager defines the interface for managing clients and broadcasting messages.
 	Manager interface {
-		Send(message Envelope)
-		Handle(w http.ResponseWriter, r *http.Request, cl Listener)
-		Clients() []string
+		Send(message Envelope)                                      // Send broadcasts a message to all clients.
+		SendTo(clientID string, message Envelope)                   // SendTo sends a message to a single client.
+		Publish(topic string, message Envelope)                     // Publish sends a message to the clients subscribed to the topic.
+		Subscribe(clientID string, topics ...string) error          // Subscribe subscribes a connected client to the topics.
+		Unsubscribe(clientID string, topics ...string) error        // Unsubscribe unsubscribes a connected client from the topics.
+		Handle(w http.ResponseWriter, r *http.Request, cl Listener) // Handle sets up a client connection and writes its messages.
+		Clients(topics ...string) []string                          // Clients lists the connected clients, subscribed to any of the topics when given.
+		Shutdown(ctx context.Context) error                         // Shutdown stops accepting messages and closes the connections.
 	}
 
+	// History keeps the messages that are replayed to clients that reconnect with a Last-Event-ID.
 	History interface {
-		Add(message Envelope) // Add adds a message to the history.
-		Send(c Listener)      // Send sends the history to a client.
+		Add(record Record)        // Add adds a message to the history.
+		Since(id uint64) []Record // Since returns the messages after the id, oldest first.
 	}
 )
 
 type Client struct {
 	id string
 	ch chan Envelope
+
+	mu     sync.RWMutex
+	topics map[string]bool
 }
 
-func NewClient(id string) Listener {
-	return &Client{
-		id: id,
-		ch: make(chan Envelope, 50),
+// NewClient returns a new client that is subscribed to the given topics.
+func NewClient(id string, topics ...string) Listener {
+	c := &Client{
+		id:     id,
+		ch:     make(chan Envelope, 50),
+		topics: make(map[string]bool),
 	}
+	c.Subscribe(topics...)
+
+	return c
 }
 
 func (c *Client) ID() string          { return c.id }
 func (c *Client) Chan() chan Envelope { return c.ch }
 
+// Subscribe subscribes the client to the topics.
+func (c *Client) Subscribe(topics ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, topic := range topics {
+		c.topics[topic] = true
+	}
+}
+
+// Unsubscribe unsubscribes the client from the topics.
+func (c *Client) Unsubscribe(topics ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, topic := range topics {
+		delete(c.topics, topic)
+	}
+}
+
+// Subscribed returns true if the client is subscribed to the topic.
+func (c *Client) Subscribed(topic string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.topics[topic]
+}
+
 // Message represents a simple message implementation.
 type Message struct {
-	Event string
-	Time  time.Time
-	Data  string
+	ID      string        // ID sets the last event id of the client, it is sent back in the Last-Event-ID header when reconnecting. A Manager replaces it with the id it assigns.
+	Event   string        // Event is the event name, the client dispatches a "message" event when empty.
+	Retry   time.Duration // Retry tells the client how long to wait before reconnecting, it is not sent when zero.
+	Comment string        // Comment is sent as comment lines, which clients ignore. Useful to keep connections alive.
+	Time    time.Time
+	Data    string
 }
 
 // NewMessage returns a new message instance.
@@ -63,38 +137,178 @@ func NewMessage(data string) *Message {
 	}
 }
 
-// String returns the message as a string.
+// String returns the message in the event stream format.
+// Multi-line data is split into several data lines, line breaks in the other fields are removed
+// since they would end the field.
 func (m *Message) String() string {
 	sb := strings.Builder{}
 
-	if m.Event != "" {
-		sb.WriteString(fmt.Sprintf("event: %s\n", m.Event))
+	if m.Comment != "" {
+		for _, line := range splitLines(m.Comment) {
+			sb.WriteString(": " + line + "\n")
+		}
+	}
+
+	if id := sanitize(m.ID); id != "" {
+		sb.WriteString("id: " + id + "\n")
 	}
-	sb.WriteString(fmt.Sprintf("data: %v\n\n", m.Data))
+
+	if event := sanitize(m.Event); event != "" {
+		sb.WriteString("event: " + event + "\n")
+	}
+
+	if m.Retry > 0 {
+		sb.WriteString(fmt.Sprintf("retry: %d\n", m.Retry.Milliseconds()))
+	}
+
+	// a message with only a comment or a retry is not dispatched as an event by the client
+	if m.Data != "" || m.Event != "" || (m.Comment == "" && m.Retry <= 0) {
+		for _, line := range splitLines(m.Data) {
+			sb.WriteString("data: " + line + "\n")
+		}
+	}
+
+	sb.WriteString("\n")
 
 	return sb.String()
 }
 
 // WithEvent sets the event name for the message.
-func (m *Message) WithEvent(event string) Envelope {
+func (m *Message) WithEvent(event string) *Message {
 	m.Event = event
 	return m
 }
 
+// WithID sets the event id for the message, for messages that are written without a Manager.
+// A Manager replaces the id with the increasing id it assigns, which replays missed messages from the Last-Event-ID.
+func (m *Message) WithID(id string) *Message {
+	m.ID = id
+	return m
+}
+
+// WithRetry sets the reconnection time for the message.
+func (m *Message) WithRetry(retry time.Duration) *Message {
+	m.Retry = retry
+	return m
+}
+
+// WithComment sets the comment for the message.
+func (m *Message) WithComment(comment string) *Message {
+	m.Comment = comment
+	return m
+}
+
+// splitLines splits the value on the line endings of the event stream format: CRLF, LF and CR
+func splitLines(value string) []string {
+	value = strings.ReplaceAll(value, "\r\n", "\n")
+	value = strings.ReplaceAll(value, "\r", "\n")
+
+	return strings.Split(value, "\n")
+}
+
+// sanitize removes the characters that would end a field, or make the client ignore it
+func sanitize(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == 0 {
+			return -1
+		}
+		return r
+	}, value)
+}
+
 // broadcastManager manages the clients and broadcasts messages to them.
 type broadcastManager struct {
 	clients        sync.Map
-	broadcast      chan Envelope
+	queues         []chan Record
 	workerPoolSize int
-	messageHistory *history
+	messageHistory History
+	lastID         atomic.Uint64
+	heartbeat      time.Duration
+	writeTimeout   time.Duration
+	maxLifetime    time.Duration
+	reconnectDelay time.Duration
+	onConnect      func(cl Listener)
+	onDisconnect   func(cl Listener)
+	shutdown       Envelope
+
+	// mu guards closed, sends hold the read lock so Shutdown can close the queues safely
+	mu        sync.RWMutex
+	closed    bool
+	done      chan struct{}
+	closeDone sync.Once
+	sendMu    sync.Mutex
+	workers   sync.WaitGroup
+	conns     sync.WaitGroup
+}
+
+// Option configures the manager created by NewManager.
+type Option func(manager *broadcastManager)
+
+// WithHistory sets the History that is used to replay missed messages to reconnecting clients.
+func WithHistory(history History) Option {
+	return func(manager *broadcastManager) {
+		manager.messageHistory = history
+	}
+}
+
+// WithHeartbeat writes a comment to every client that has not received a message for the interval,
+// which keeps proxies and load balancers from closing idle connections.
+func WithHeartbeat(interval time.Duration) Option {
+	return func(manager *broadcastManager) {
+		manager.heartbeat = interval
+	}
+}
+
+// WithWriteTimeout sets the deadline for writing a message to a client, a client that does not keep up is disconnected.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(manager *broadcastManager) {
+		manager.writeTimeout = timeout
+	}
+}
+
+// WithMaxLifetime closes connections after the lifetime, after telling the browser to reconnect after the delay.
+// The browser reconnects with the Last-Event-ID, so no messages are lost.
+// The DefaultReconnectDelay is used when the delay is not positive.
+func WithMaxLifetime(lifetime, reconnectDelay time.Duration) Option {
+	return func(manager *broadcastManager) {
+		if reconnectDelay <= 0 {
+			reconnectDelay = DefaultReconnectDelay
+		}
+
+		manager.maxLifetime = lifetime
+		manager.reconnectDelay = reconnectDelay
+	}
+}
+
+// event is a message with the id that is assigned by the manager
+type event struct {
+	id      uint64
+	message Envelope
+}
+
+// String returns the message with the id of the event, which replaces the id of the message
+func (e *event) String() string {
+	id := strconv.FormatUint(e.id, 10)
+
+	if msg, ok := e.message.(*Message); ok {
+		withID := *msg
+		withID.ID = id
+		return withID.String()
+	}
+
+	return "id: " + id + "\n" + e.message.String()
 }
 
 // NewManager initializes and returns a new Manager instance.
-func NewManager(workerPoolSize int) Manager {
+func NewManager(workerPoolSize int, opts ...Option) Manager {
 	manager := &broadcastManager{
-		broadcast:      make(chan Envelope),
 		workerPoolSize: workerPoolSize,
-		messageHistory: newHistory(10),
+		messageHistory: NewHistory(DefaultHistorySize),
+		done:           make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(manager)
 	}
 
 	manager.startWorkers()
@@ -104,19 +318,125 @@ func NewManager(workerPoolSize int) Manager {
 
 // Send broadcasts a message to all connected clients.
 func (manager *broadcastManager) Send(message Envelope) {
-	manager.broadcast <- message
+	manager.send(Record{Message: message})
+}
+
+// SendTo sends a message to the client with the given id, the message is dropped when the client is not connected.
+func (manager *broadcastManager) SendTo(clientID string, message Envelope) {
+	manager.send(Record{Message: message, ClientID: clientID})
+}
+
+// Publish sends a message to the connected clients that are subscribed to the topic.
+func (manager *broadcastManager) Publish(topic string, message Envelope) {
+	manager.send(Record{Message: message, Topic: topic})
+}
+
+// send assigns the next id to the message, adds it to the history once and hands it to every worker.
+// The id is assigned and the message is queued under one lock, so every worker receives the messages in id order.
+// Messages sent after Shutdown are dropped.
+func (manager *broadcastManager) send(record Record) {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+
+	if manager.closed {
+		return
+	}
+
+	manager.sendMu.Lock()
+	defer manager.sendMu.Unlock()
+
+	record.ID = manager.lastID.Add(1)
+	record.Message = &event{id: record.ID, message: record.Message}
+
+	if manager.messageHistory != nil {
+		manager.messageHistory.Add(record)
+	}
+
+	for _, queue := range manager.queues {
+		queue <- record
+	}
+}
+
+// Subscribe subscribes the connected client with the given id to the topics.
+func (manager *broadcastManager) Subscribe(clientID string, topics ...string) error {
+	subscriber, err := manager.subscriber(clientID)
+	if err != nil {
+		return err
+	}
+
+	subscriber.Subscribe(topics...)
+	return nil
+}
+
+// Unsubscribe unsubscribes the connected client with the given id from the topics.
+func (manager *broadcastManager) Unsubscribe(clientID string, topics ...string) error {
+	subscriber, err := manager.subscriber(clientID)
+	if err != nil {
+		return err
+	}
+
+	subscriber.Unsubscribe(topics...)
+	return nil
+}
+
+// subscriber returns the connected client with the given id when it supports topics
+func (manager *broadcastManager) subscriber(clientID string) (Subscriber, error) {
+	value, ok := manager.clients.Load(clientID)
+	if !ok {
+		return nil, fmt.Errorf("client %q is not connected", clientID)
+	}
+
+	subscriber, ok := value.(Subscriber)
+	if !ok {
+		return nil, fmt.Errorf("client %q does not support topics", clientID)
+	}
+
+	return subscriber, nil
 }
 
 // Handle sets up a new client and handles the connection.
+// A reconnecting client that sends the Last-Event-ID header first receives the messages it missed.
+// Between messages a heartbeat comment is written when configured, and the connection is closed
+// with a retry hint once it reaches the maximum lifetime, so the browser reconnects.
 func (manager *broadcastManager) Handle(w http.ResponseWriter, r *http.Request, cl Listener) {
-	manager.register(cl)
+	if !manager.connect(cl) {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer manager.disconnect(cl)
 
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	// Send history to the newly connected client
-	manager.messageHistory.Send(cl)
+	conn := &connection{
+		w:            w,
+		rc:           http.NewResponseController(w),
+		writeTimeout: manager.writeTimeout,
+	}
+
+	// Send the missed messages to the reconnecting client
+	replayed, err := manager.replay(conn, r, cl)
+	if err != nil {
+		return
+	}
+
+	var (
+		ticker    *time.Ticker
+		heartbeat <-chan time.Time
+	)
+	if manager.heartbeat > 0 {
+		ticker = time.NewTicker(manager.heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	var expired <-chan time.Time
+	if manager.maxLifetime > 0 {
+		timer := time.NewTimer(manager.maxLifetime)
+		defer timer.Stop()
+		expired = timer.C
+	}
 
 	for {
 		select {
@@ -125,29 +445,108 @@ func (manager *broadcastManager) Handle(w http.ResponseWriter, r *http.Request,
 				// If the channel is closed, return from the function
 				return
 			}
-			_, err := fmt.Fprint(w, msg.String())
-			if err != nil {
+
+			// skip the messages that were sent while replaying the history
+			if e, ok := msg.(*event); ok && e.id <= replayed {
+				continue
+			}
+
+			if err = conn.write(msg); err != nil {
 				// If an error occurs (e.g., client has disconnected), return from the function
 				return
 			}
-			if flusher, ok := w.(http.Flusher); ok {
-				flusher.Flush()
+
+			// the connection is not idle, postpone the heartbeat
+			if ticker != nil {
+				ticker.Reset(manager.heartbeat)
 			}
 
+		case <-heartbeat:
+			if err = conn.write(NewMessage("").WithComment(HeartbeatComment)); err != nil {
+				return
+			}
+
+		case <-expired:
+			// tell the browser to reconnect soon, it resumes with the Last-Event-ID
+			_ = conn.write(NewMessage("").WithRetry(manager.reconnectDelay))
+			return
+
+		case <-manager.done:
+			// write the messages that were queued before the shutdown, followed by the final message
+			manager.drain(conn, cl, replayed)
+			_ = conn.write(manager.shutdownMessage())
+			return
+
 		case <-r.Context().Done():
-			manager.unregister(cl.ID())
-			close(cl.Chan())
+			// the channel of the client is not closed, a worker might still be sending to it
 			return
 		}
 	}
 }
 
-// Clients method to list connected client IDs
-func (manager *broadcastManager) Clients() []string {
+// connection writes messages to a single client
+type connection struct {
+	w            http.ResponseWriter
+	rc           *http.ResponseController
+	writeTimeout time.Duration
+}
+
+// write writes and flushes the message, within the write timeout when one is set
+func (c *connection) write(msg Envelope) error {
+	if c.writeTimeout > 0 {
+		// not every ResponseWriter supports deadlines, the message is written without one
+		_ = c.rc.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+
+	if _, err := fmt.Fprint(c.w, msg.String()); err != nil {
+		return err
+	}
+
+	if err := c.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	return nil
+}
+
+// replay writes the messages of the history the client missed since the Last-Event-ID, and returns the id of the last one
+func (manager *broadcastManager) replay(conn *connection, r *http.Request, cl Listener) (uint64, error) {
+	header := r.Header.Get(LastEventIDHeader)
+	if header == "" || manager.messageHistory == nil {
+		return 0, nil
+	}
+
+	lastID, err := strconv.ParseUint(header, 10, 64)
+	if err != nil {
+		return 0, nil
+	}
+
+	// an id the manager has not assigned yet comes from before a restart, all the history is new to the client
+	if lastID > manager.lastID.Load() {
+		lastID = 0
+	}
+
+	var replayed uint64
+	for _, record := range manager.messageHistory.Since(lastID) {
+		if !record.matches(cl) {
+			continue
+		}
+
+		if err = conn.write(record.Message); err != nil {
+			return 0, err
+		}
+		replayed = record.ID
+	}
+
+	return replayed, nil
+}
+
+// Clients method to list connected client IDs, when topics are given only the clients subscribed to any of them are listed
+func (manager *broadcastManager) Clients(topics ...string) []string {
 	var clients []string
 	manager.clients.Range(func(key, value any) bool {
 		id, ok := key.(string)
-		if ok {
+		if ok && subscribedToAny(value, topics) {
 			clients = append(clients, id)
 		}
 		return true
@@ -155,19 +554,50 @@ func (manager *broadcastManager) Clients() []string {
 	return clients
 }
 
+// subscribedToAny returns true if no topics are given, or if the client is subscribed to any of the topics
+func subscribedToAny(client any, topics []string) bool {
+	if len(topics) == 0 {
+		return true
+	}
+
+	subscriber, ok := client.(Subscriber)
+	if !ok {
+		return false
+	}
+
+	for _, topic := range topics {
+		if subscriber.Subscribed(topic) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // startWorkers starts worker goroutines for message broadcasting.
+// Every worker receives every message and delivers it to its own share of the clients, so the messages
+// of a client are always delivered by the same worker, in the order they were sent.
 func (manager *broadcastManager) startWorkers() {
-	for i := 0; i < manager.workerPoolSize; i++ {
+	workers := uint32(manager.workerPoolSize)
+	manager.queues = make([]chan Record, manager.workerPoolSize)
+
+	for i := range manager.queues {
+		queue := make(chan Record)
+		manager.queues[i] = queue
+		shard := uint32(i)
+
+		manager.workers.Add(1)
 		go func() {
-			for message := range manager.broadcast {
+			defer manager.workers.Done()
+
+			for record := range queue {
 				manager.clients.Range(func(key, value any) bool {
 					client, ok := value.(Listener)
-					if !ok {
+					if !ok || clientShard(client.ID(), workers) != shard || !record.matches(client) {
 						return true // Continue iteration
 					}
 					select {
-					case client.Chan() <- message:
-						manager.messageHistory.Add(message)
+					case client.Chan() <- record.Message:
 					default:
 						// If the client's channel is full, drop the message
 					}
@@ -178,39 +608,21 @@ func (manager *broadcastManager) startWorkers() {
 	}
 }
 
-// register adds a client to the manager.
-func (manager *broadcastManager) register(client Listener) {
-	manager.clients.Store(client.ID(), client)
-}
+// clientShard returns the worker that delivers the messages of the client
+func clientShard(clientID string, workers uint32) uint32 {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(clientID))
 
-// unregister removes a client from the manager.
-func (manager *broadcastManager) unregister(clientID string) {
-	manager.clients.Delete(clientID)
+	return hash.Sum32() % workers
 }
 
-type history struct {
-	messages []Envelope
-	maxSize  int // Maximum number of messages to retain
-}
-
-func newHistory(maxSize int) *history {
-	return &history{
-		messages: []Envelope{},
-		maxSize:  maxSize,
-	}
-}
-
-func (h *history) Add(message Envelope) {
-	h.messages = append(h.messages, message)
-	// Ensure history does not exceed maxSize
-	if len(h.messages) > h.maxSize {
-		// Remove the oldest messages to fit the maxSize
-		h.messages = h.messages[len(h.messages)-h.maxSize:]
-	}
+// register adds a client to the manager.
+func (manager *broadcastManager) register(client Listener) {
+	manager.clients.Store(client.ID(), client)
 }
 
-func (h *history) Send(c Listener) {
-	for _, msg := range h.messages {
-		c.Chan() <- msg
-	}
+// unregister removes a client from the manager, unless a new connection with the same id replaced it.
+// It reports whether the client was removed.
+func (manager *broadcastManager) unregister(client Listener) bool {
+	return manager.clients.CompareAndDelete(client.ID(), client)
 }
@@ -0,0 +1,535 @@
+package sse
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receive returns the data of the messages the client received until the timeout
+func receive(t *testing.T, cl Listener, n int) []string {
+	t.Helper()
+
+	var out []string
+	for len(out) < n {
+		select {
+		case msg := <-cl.Chan():
+			out = append(out, msg.(*event).message.(*Message).Data)
+		case <-time.After(time.Second):
+			t.Fatalf("client %s received %v, expected %d messages", cl.ID(), out, n)
+		}
+	}
+
+	return out
+}
+
+func TestManagerDelivery(t *testing.T) {
+	manager := NewManager(1).(*broadcastManager)
+
+	alice := NewClient("alice", "team-a", "doc-1")
+	bob := NewClient("bob", "team-b")
+	carol := NewClient("carol")
+
+	for _, cl := range []Listener{alice, bob, carol} {
+		manager.register(cl)
+	}
+
+	if err := manager.Subscribe("carol", "doc-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.Subscribe("dave", "doc-1"); err == nil {
+		t.Error("expected an error for a client that is not connected")
+	}
+
+	manager.SendTo("bob", NewMessage("to bob"))
+	manager.Publish("doc-1", NewMessage("doc-1"))
+	manager.Publish("team-b", NewMessage("team-b"))
+	manager.Send(NewMessage("everyone"))
+
+	tests := map[Listener][]string{
+		alice: {"doc-1", "everyone"},
+		bob:   {"to bob", "team-b", "everyone"},
+		carol: {"doc-1", "everyone"},
+	}
+
+	for cl, expected := range tests {
+		if got := receive(t, cl, len(expected)); strings.Join(got, ",") != strings.Join(expected, ",") {
+			t.Errorf("expected %s to receive %v, got %v", cl.ID(), expected, got)
+		}
+	}
+
+	clients := manager.Clients("doc-1")
+	sort.Strings(clients)
+	if strings.Join(clients, ",") != "alice,carol" {
+		t.Errorf("expected alice and carol to watch doc-1, got %v", clients)
+	}
+
+	if len(manager.Clients()) != 3 {
+		t.Errorf("expected 3 clients, got %v", manager.Clients())
+	}
+
+	if err := manager.Unsubscribe("alice", "doc-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if clients = manager.Clients("doc-1"); len(clients) != 1 || clients[0] != "carol" {
+		t.Errorf("expected only carol to watch doc-1, got %v", clients)
+	}
+}
+
+// dispatched is an event as an EventSource dispatches it
+type dispatched struct {
+	id    string
+	name  string
+	data  string
+	retry string
+}
+
+// parseStream parses the event stream following the EventSource interpretation rules:
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+func parseStream(stream string) []dispatched {
+	var (
+		events []dispatched
+		data   strings.Builder
+		name   string
+		lastID string
+		retry  string
+	)
+
+	stream = strings.ReplaceAll(stream, "\r\n", "\n")
+	stream = strings.ReplaceAll(stream, "\r", "\n")
+
+	for _, line := range strings.Split(stream, "\n") {
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				name = ""
+				continue
+			}
+			events = append(events, dispatched{id: lastID, name: name, data: strings.TrimSuffix(data.String(), "\n"), retry: retry})
+			data.Reset()
+			name = ""
+		case strings.HasPrefix(line, ":"):
+			// comment
+		default:
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+
+			switch field {
+			case "event":
+				name = value
+			case "data":
+				data.WriteString(value + "\n")
+			case "id":
+				if !strings.ContainsRune(value, 0) {
+					lastID = value
+				}
+			case "retry":
+				retry = value
+			}
+		}
+	}
+
+	return events
+}
+
+func TestManagerDeliveryOrder(t *testing.T) {
+	manager := NewManager(5).(*broadcastManager)
+
+	clients := make([]Listener, 50)
+	for i := range clients {
+		clients[i] = NewClient("client-" + strconv.Itoa(i))
+		manager.register(clients[i])
+	}
+
+	const messages = 2000
+
+	var wg sync.WaitGroup
+	for _, cl := range clients {
+		wg.Add(1)
+		go func(cl Listener) {
+			defer wg.Done()
+
+			// messages may be dropped when the channel is full, but never delivered out of order
+			var last uint64
+			for {
+				select {
+				case msg := <-cl.Chan():
+					id := msg.(*event).id
+					if id <= last {
+						t.Errorf("client %s received %d after %d", cl.ID(), id, last)
+					}
+					last = id
+
+					if id == messages {
+						return
+					}
+				case <-time.After(time.Second):
+					// the last message was dropped
+					return
+				}
+			}
+		}(cl)
+	}
+
+	for i := 0; i < messages; i++ {
+		manager.Send(NewMessage(strconv.Itoa(i)))
+	}
+
+	wg.Wait()
+}
+
+func TestMessageString(t *testing.T) {
+	msg := NewMessage("<ul>\n  <li>one</li>\r\n  <li>two</li>\r</ul>").
+		WithEvent("list\nupdate").
+		WithID("42").
+		WithRetry(3 * time.Second).
+		WithComment("first\nsecond")
+
+	expected := ": first\n: second\nid: 42\nevent: listupdate\nretry: 3000\n" +
+		"data: <ul>\ndata:   <li>one</li>\ndata:   <li>two</li>\ndata: </ul>\n\n"
+
+	if msg.String() != expected {
+		t.Errorf("expected %q, got %q", expected, msg.String())
+	}
+
+	events := parseStream(msg.String())
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %v", events)
+	}
+
+	got := events[0]
+	if got.data != "<ul>\n  <li>one</li>\n  <li>two</li>\n</ul>" || got.name != "listupdate" || got.id != "42" || got.retry != "3000" {
+		t.Errorf("unexpected event %+v", got)
+	}
+}
+
+func TestMessageStringControl(t *testing.T) {
+	tests := map[string]*Message{
+		"data: \n\n":                 NewMessage(""),
+		"data: :colon\n\n":           NewMessage(":colon"),
+		": keep-alive\n\n":           NewMessage("").WithComment("keep-alive"),
+		"retry: 1500\n\n":            NewMessage("").WithRetry(1500 * time.Millisecond),
+		"event: ping\ndata: \n\n":    NewMessage("").WithEvent("ping"),
+		"data: \ndata: trailing\n\n": NewMessage("\ntrailing"),
+	}
+
+	for expected, msg := range tests {
+		if msg.String() != expected {
+			t.Errorf("expected %q, got %q", expected, msg.String())
+		}
+	}
+
+	// comments and retries are not dispatched, the stream stays in sync
+	stream := NewMessage("").WithComment("keep-alive").String() +
+		NewMessage("").WithRetry(time.Second).String() +
+		NewMessage("a").WithID("1").String() +
+		NewMessage("b").String()
+
+	events := parseStream(stream)
+	if len(events) != 2 || events[0].data != "a" || events[1].data != "b" || events[1].id != "1" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	history := NewHistory(2)
+	for id := uint64(1); id <= 3; id++ {
+		history.Add(Record{ID: id, Message: NewMessage(strconv.FormatUint(id, 10))})
+	}
+
+	records := history.Since(0)
+	if len(records) != 2 || records[0].ID != 2 || records[1].ID != 3 {
+		t.Errorf("expected the last two records, got %v", records)
+	}
+
+	if records = history.Since(2); len(records) != 1 || records[0].ID != 3 {
+		t.Errorf("expected the records after 2, got %v", records)
+	}
+}
+
+// handle connects the client with the Last-Event-ID and returns what was written until the connection is closed
+func handle(manager Manager, cl Listener, lastEventID string) string {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := httptest.NewRequest(http.MethodGet, "/sse", nil).WithContext(ctx)
+	if lastEventID != "" {
+		r.Header.Set(LastEventIDHeader, lastEventID)
+	}
+
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		manager.Handle(w, r, cl)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	return w.Body.String()
+}
+
+func TestManagerReplay(t *testing.T) {
+	manager := NewManager(1, WithHistory(NewHistory(4)))
+
+	manager.Send(NewMessage("a"))
+	manager.Send(NewMessage("b"))
+	manager.SendTo("alice", NewMessage("c"))
+	manager.Send(NewMessage("d").WithID("custom"))
+
+	// the single worker has delivered d once it accepts the next message
+	manager.SendTo("nobody", NewMessage("e"))
+
+	tests := map[string]string{
+		"":    "",
+		"1":   "2:b,4:d",
+		"3":   "4:d",
+		"4":   "",
+		"99":  "2:b,4:d",
+		"bad": "",
+	}
+
+	for lastEventID, expected := range tests {
+		var got []string
+		for _, e := range parseStream(handle(manager, NewClient("bob"), lastEventID)) {
+			got = append(got, e.id+":"+e.data)
+		}
+
+		if strings.Join(got, ",") != expected {
+			t.Errorf("expected Last-Event-ID %q to replay %q, got %q", lastEventID, expected, strings.Join(got, ","))
+		}
+	}
+
+	var got []string
+	for _, e := range parseStream(handle(manager, NewClient("alice"), "1")) {
+		got = append(got, e.data)
+	}
+
+	if strings.Join(got, ",") != "b,c,d" {
+		t.Errorf("expected alice to receive her own message, got %v", got)
+	}
+}
+
+func TestManagerReplacesID(t *testing.T) {
+	manager := NewManager(1).(*broadcastManager)
+
+	alice := NewClient("alice")
+	manager.register(alice)
+
+	manager.Send(NewMessage("a").WithID("custom"))
+
+	select {
+	case msg := <-alice.Chan():
+		// the id of the manager replaces the id of the message, so reconnecting replays from it
+		events := parseStream(msg.String())
+		if len(events) != 1 || events[0].id != "1" || events[0].data != "a" {
+			t.Errorf("expected the manager id to replace the message id, got %q", msg.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected alice to receive the message")
+	}
+}
+
+func TestManagerHeartbeat(t *testing.T) {
+	manager := NewManager(1, WithHeartbeat(10*time.Millisecond), WithWriteTimeout(time.Second))
+
+	body := handle(manager, NewClient("alice"), "")
+	if !strings.HasPrefix(body, ": "+HeartbeatComment+"\n\n") {
+		t.Errorf("expected heartbeat comments, got %q", body)
+	}
+
+	if events := parseStream(body); len(events) != 0 {
+		t.Errorf("expected heartbeats not to dispatch events, got %v", events)
+	}
+}
+
+func TestManagerMaxLifetime(t *testing.T) {
+	manager := NewManager(1, WithMaxLifetime(10*time.Millisecond, 500*time.Millisecond))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/sse", nil)
+
+	done := make(chan struct{})
+	go func() {
+		manager.Handle(w, r, NewClient("alice"))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the connection to be closed after its lifetime")
+	}
+
+	if w.Body.String() != "retry: 500\n\n" {
+		t.Errorf("expected a retry hint, got %q", w.Body.String())
+	}
+
+	if len(manager.Clients()) != 0 {
+		t.Errorf("expected the client to be unregistered, got %v", manager.Clients())
+	}
+}
+
+func TestManagerMaxLifetimeDefaultDelay(t *testing.T) {
+	manager := NewManager(1, WithMaxLifetime(10*time.Millisecond, 0))
+
+	w := httptest.NewRecorder()
+	manager.Handle(w, httptest.NewRequest(http.MethodGet, "/sse", nil), NewClient("alice"))
+
+	want := "retry: " + strconv.FormatInt(DefaultReconnectDelay.Milliseconds(), 10) + "\n\n"
+	if w.Body.String() != want {
+		t.Errorf("expected the default retry hint %q, got %q", want, w.Body.String())
+	}
+
+	if events := parseStream(w.Body.String()); len(events) != 0 {
+		t.Errorf("expected the retry hint not to dispatch events, got %v", events)
+	}
+}
+
+func TestManagerReconnect(t *testing.T) {
+	connected := make(chan Listener, 2)
+	disconnected := make(chan Listener, 2)
+
+	manager := NewManager(1,
+		OnConnect(func(cl Listener) { connected <- cl }),
+		OnDisconnect(func(cl Listener) { disconnected <- cl }),
+	).(*broadcastManager)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/sse", nil).WithContext(ctx)
+
+	old := NewClient("alice")
+	done := make(chan struct{})
+	go func() {
+		manager.Handle(httptest.NewRecorder(), r, old)
+		close(done)
+	}()
+	<-connected
+
+	// the browser reconnects before the old connection notices it is gone
+	reconnected := NewClient("alice")
+	manager.register(reconnected)
+
+	cancel()
+	<-done
+
+	value, ok := manager.clients.Load("alice")
+	if !ok || value != Listener(reconnected) {
+		t.Fatalf("expected the reconnected client to stay registered, got %v", value)
+	}
+
+	select {
+	case cl := <-disconnected:
+		t.Errorf("expected no disconnect for a replaced connection, got %s", cl.ID())
+	default:
+	}
+
+	manager.SendTo("alice", NewMessage("still here"))
+	if got := receive(t, reconnected, 1); got[0] != "still here" {
+		t.Errorf("expected the reconnected client to receive messages, got %v", got)
+	}
+}
+
+func TestManagerShutdown(t *testing.T) {
+	connected := make(chan string, 1)
+	disconnected := make(chan string, 1)
+
+	manager := NewManager(2,
+		OnConnect(func(cl Listener) { connected <- cl.ID() }),
+		OnDisconnect(func(cl Listener) { disconnected <- cl.ID() }),
+	)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/sse", nil)
+
+	done := make(chan struct{})
+	go func() {
+		manager.Handle(w, r, NewClient("alice"))
+		close(done)
+	}()
+
+	if id := <-connected; id != "alice" {
+		t.Errorf("expected alice to connect, got %s", id)
+	}
+
+	manager.Send(NewMessage("last"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := manager.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Handle to return after the shutdown")
+	}
+
+	if id := <-disconnected; id != "alice" {
+		t.Errorf("expected alice to disconnect, got %s", id)
+	}
+
+	var got []string
+	for _, e := range parseStream(w.Body.String()) {
+		got = append(got, e.name+":"+e.data)
+	}
+
+	if strings.Join(got, ",") != ":last,"+ShutdownEvent+":" {
+		t.Errorf("expected the queued message and the shutdown event, got %v", got)
+	}
+
+	// sending after the shutdown does not block
+	manager.Send(NewMessage("dropped"))
+
+	if err := manager.Shutdown(ctx); err != nil {
+		t.Errorf("expected a second shutdown to succeed, got %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	manager.Handle(w, r, NewClient("bob"))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected new connections to be refused, got %d", w.Code)
+	}
+}
+
+func TestManagerShutdownCancelled(t *testing.T) {
+	connected := make(chan struct{}, 1)
+	manager := NewManager(1, OnConnect(func(Listener) { connected <- struct{}{} }))
+
+	done := make(chan struct{})
+	go func() {
+		manager.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/sse", nil), NewClient("alice"))
+		close(done)
+	}()
+	<-connected
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the context is done before anything is waited for, the connections are ended anyway
+	_ = manager.Shutdown(ctx)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Handle to return after a shutdown with a cancelled context")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := manager.Shutdown(ctx); err != nil {
+		t.Errorf("expected a second shutdown to wait for the connections, got %v", err)
+	}
+}
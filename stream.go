@@ -0,0 +1,252 @@
+package htmx
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"io"
+	"sync"
+	"text/template/parse"
+)
+
+// maxPooledBufferSize is the capacity above which buffers are not returned to the pool, so one huge page does not keep its memory
+const maxPooledBufferSize = 1 << 20
+
+// bufferPool holds the buffers components are rendered into when their output can not be streamed
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// streamTargets holds the targets of the {{ .Partial }} calls of the cached templates by their cache key
+var streamTargets sync.Map
+
+// getBuffer returns an empty buffer from the pool
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns the buffer to the pool
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// countWriter counts the bytes written to the underlying writer
+type countWriter struct {
+	w io.Writer
+	n int
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += n
+
+	return n, err
+}
+
+// RenderStream renders the given renderer like Render, but executes the templates straight into the response instead of
+// rendering every level of the layout into a buffer first. The outermost layout is executed into the response, and the
+// wrapped component is executed when the layout reaches {{ .Partial "target" }}. Layouts that use {{ .Partials.target }}
+// instead get the wrapped component rendered into a buffer first, like Render does.
+// Partials added with With and slots are still rendered before the layout.
+// The response has started when rendering fails half-way, use a buffered handler to be able to write an error response.
+func (h *Handler) RenderStream(ctx context.Context, r RenderableComponent) (int, error) {
+	partial := h.RenderPartial()
+	targets := r.targetBlocks()
+
+	var block string
+	if partial {
+		block = targets[h.request.TargetID()]
+	}
+
+	// The headers have to be set before the first byte is written
+	if h.vary {
+		h.Vary(h.request.Version.VaryHeaders()...)
+
+		if len(targets) > 0 {
+			h.Vary(HxRequestHeaderTarget)
+		}
+	}
+
+	h.refreshTemplates(partial)
+
+	cw := &countWriter{w: h}
+
+	var err error
+	if partial {
+		err = r.execute(ctx, cw, renderInput{url: h.r.URL, block: block, cache: h.cache, log: h.log})
+		if err == nil {
+			var oob template.HTML
+			oob, err = h.renderOOB(ctx)
+			if err == nil {
+				_, err = io.WriteString(cw, string(oob))
+			}
+		}
+	} else {
+		var exec streamFunc
+		exec, err = h.streamWrapped(ctx, r)
+		if err == nil {
+			err = exec(cw)
+		}
+	}
+
+	if err != nil {
+		// an unbuffered response that has started can not be replaced by an error response
+		if cw.n > 0 && !h.buffered {
+			return cw.n, withChain(r, err)
+		}
+
+		return h.failRender(ctx, r, err)
+	}
+
+	return cw.n, nil
+}
+
+// streamWrapped returns the function that executes the outermost layout of the component,
+// with every wrapped component as a lazy partial of its wrapper.
+// Data, slots and the model are handed up the wrap chain like wrapOutput does.
+func (h *Handler) streamWrapped(ctx context.Context, r RenderableComponent) (streamFunc, error) {
+	// the With partials are rendered once for the component, its slots and its wrappers
+	rendered := make(renderedPartials)
+
+	exec := streamFunc(func(w io.Writer) error {
+		return r.execute(ctx, w, renderInput{url: h.r.URL, cache: h.cache, log: h.log, rendered: rendered})
+	})
+
+	model, hasModel := r.model()
+	in := renderInput{data: r.data(), model: model, hasModel: hasModel}
+
+	for level := r; level.isWrapped(); level = level.wrapper() {
+		own, err := level.renderSlots(ctx, renderInput{data: in.data, url: h.r.URL, cache: h.cache, log: h.log, model: in.model, hasModel: in.hasModel, rendered: rendered})
+		if err != nil {
+			return nil, err
+		}
+
+		slots := mergeSlots(own, in.slots)
+		parent := level.wrapper()
+		target := level.target()
+		parentIn := renderInput{
+			data:     in.data,
+			url:      h.r.URL,
+			cache:    h.cache,
+			log:      h.log,
+			slots:    slots,
+			model:    in.model,
+			hasModel: in.hasModel,
+			rendered: rendered,
+		}
+
+		child := exec
+		if parent.streamsPartial(target, h.cache, h.log) {
+			parentIn.lazy = map[string]streamFunc{target: child}
+
+			exec = func(w io.Writer) error {
+				return parent.execute(ctx, w, parentIn)
+			}
+		} else {
+			// the layout uses {{ .Partials.target }}, the wrapped component has to be rendered before the layout
+			exec = func(w io.Writer) error {
+				buf := getBuffer()
+				defer putBuffer(buf)
+
+				if err := child(buf); err != nil {
+					return err
+				}
+
+				buffered := parentIn
+				buffered.partials = map[string]any{target: template.HTML(buf.String())}
+
+				return parent.execute(ctx, w, buffered)
+			}
+		}
+
+		model, hasModel := parent.model()
+		if !hasModel {
+			model, hasModel = in.model, in.hasModel
+		}
+
+		in = renderInput{data: mergeMap(parent.data(), in.data), slots: slots, model: model, hasModel: hasModel}
+	}
+
+	return exec, nil
+}
+
+// partialTargets returns the targets of the {{ .Partial "target" }} calls in the templates.
+// It must be called before the templates are executed, html/template rewrites the trees when it escapes them.
+func partialTargets(tmpl *template.Template) map[string]bool {
+	targets := make(map[string]bool)
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			collectPartialTargets(t.Tree.Root, targets)
+		}
+	}
+
+	return targets
+}
+
+// collectPartialTargets adds the targets of the {{ .Partial "target" }} calls below the node
+func collectPartialTargets(node parse.Node, targets map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+
+		for _, child := range n.Nodes {
+			collectPartialTargets(child, targets)
+		}
+	case *parse.ActionNode:
+		collectPartialTargets(n.Pipe, targets)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+
+		for _, cmd := range n.Cmds {
+			collectPartialTargets(cmd, targets)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) > 1 && isPartialMethod(n.Args[0]) {
+			if name, ok := n.Args[1].(*parse.StringNode); ok {
+				targets[name.Text] = true
+			}
+		}
+
+		for _, arg := range n.Args {
+			collectPartialTargets(arg, targets)
+		}
+	case *parse.IfNode:
+		collectBranchTargets(&n.BranchNode, targets)
+	case *parse.RangeNode:
+		collectBranchTargets(&n.BranchNode, targets)
+	case *parse.WithNode:
+		collectBranchTargets(&n.BranchNode, targets)
+	case *parse.TemplateNode:
+		collectPartialTargets(n.Pipe, targets)
+	}
+}
+
+// collectBranchTargets adds the targets of the {{ .Partial "target" }} calls of an if, range or with
+func collectBranchTargets(n *parse.BranchNode, targets map[string]bool) {
+	collectPartialTargets(n.Pipe, targets)
+	collectPartialTargets(n.List, targets)
+	collectPartialTargets(n.ElseList, targets)
+}
+
+// isPartialMethod returns true when the node is .Partial or $.Partial
+func isPartialMethod(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		return len(n.Ident) == 1 && n.Ident[0] == "Partial"
+	case *parse.VariableNode:
+		return len(n.Ident) == 2 && n.Ident[0] == "$" && n.Ident[1] == "Partial"
+	}
+
+	return false
+}
@@ -0,0 +1,120 @@
+package htmx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+var streamFS = fstest.MapFS{
+	"base.html":    {Data: []byte(`<html><title>{{ .Slots.title }}</title>{{ .Partial "Body" }}</html>`)},
+	"section.html": {Data: []byte(`<section>{{ .Partials.Nav }}{{ .Partial "Content" }}</section>`)},
+	"nav.html":     {Data: []byte(`<nav>{{ .Data.Name }}</nav>`)},
+	"table.html":   {Data: []byte(`<table>{{ range .Data.Rows }}<tr><td>{{ . }}</td></tr>{{ end }}</table>{{ define "title" }}{{ .Data.Name }}{{ end }}`)},
+	"broken.html":  {Data: []byte(`<table>{{ index .Data.Rows 10 }}</table>`)},
+}
+
+func streamPage(page string) RenderableComponent {
+	section := NewComponent("section.html").FS(streamFS).
+		With(NewComponent("nav.html").FS(streamFS), "Nav").
+		Wrap(NewComponent("base.html").FS(streamFS), "Body")
+
+	return NewComponent(page).FS(streamFS).
+		AddData("Name", "Report").
+		AddData("Rows", []int{1, 2}).
+		Slot("title", "title").
+		Wrap(section, "Content")
+}
+
+func TestRenderStream(t *testing.T) {
+	expected := `<html><title>Report</title><section><nav>Report</nav><table><tr><td>1</td></tr><tr><td>2</td></tr></table></section></html>`
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	w := httptest.NewRecorder()
+	n, err := New().NewHandler(w, r).RenderStream(context.Background(), streamPage("table.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, expected, w.Body.String())
+	equalInt(t, len(expected), n)
+
+	// the same layouts render the same output with Render
+	w = httptest.NewRecorder()
+	if _, err = New().NewHandler(w, r).Render(context.Background(), streamPage("table.html")); err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, expected, w.Body.String())
+}
+
+func TestRenderStreamPartialsLayout(t *testing.T) {
+	layoutFS := fstest.MapFS{
+		"index.html":  {Data: []byte(`<html>{{ .Partials.Content }}</html>`)},
+		"shell.html":  {Data: []byte(`<body>{{ template "main" . }}</body>{{ define "main" }}<main>{{ $.Partial "Shell" }}</main>{{ end }}`)},
+		"report.html": {Data: []byte(`<table>{{ .Data.Name }}</table>`)},
+	}
+
+	page := NewComponent("report.html").FS(layoutFS).
+		AddData("Name", "Report").
+		Wrap(NewComponent("shell.html").FS(layoutFS).Wrap(NewComponent("index.html").FS(layoutFS), "Content"), "Shell")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	if _, err := New().NewHandler(w, r).RenderStream(context.Background(), page); err != nil {
+		t.Fatal(err)
+	}
+
+	// the layout using .Partials gets the wrapped component rendered into a buffer, the one using .Partial streams it
+	equal(t, `<html><body><main><table>Report</table></main></body></html>`, w.Body.String())
+
+	equalBool(t, true, NewComponent("shell.html").FS(layoutFS).streamsPartial("Shell", nil, nil))
+	equalBool(t, false, NewComponent("index.html").FS(layoutFS).streamsPartial("Content", nil, nil))
+}
+
+func TestRenderStreamPartial(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("HX-Request", "true")
+
+	w := httptest.NewRecorder()
+	h := New().NewHandler(w, r)
+	h.AddOOB("#nav", nil, NewComponent("nav.html").FS(streamFS).AddData("Name", "oob"))
+
+	if _, err := h.RenderStream(context.Background(), streamPage("table.html")); err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, `<table><tr><td>1</td></tr><tr><td>2</td></tr></table><nav hx-swap-oob="outerHTML:#nav">oob</nav>`, w.Body.String())
+}
+
+func TestRenderStreamError(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h := New()
+	h.SetErrorRenderer(NewErrorRenderer("#errors", nil, nil))
+
+	w := httptest.NewRecorder()
+	handler := h.NewBufferedHandler(w, r)
+
+	_, err := handler.RenderStream(context.Background(), streamPage("broken.html"))
+
+	var re *RenderError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected a RenderError, got %v", err)
+	}
+
+	equal(t, "broken.html", re.Templates[0])
+
+	if err = handler.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the layout that was already streamed into the buffer is discarded
+	equalInt(t, http.StatusInternalServerError, w.Code)
+	equal(t, http.StatusText(http.StatusInternalServerError), w.Body.String())
+}
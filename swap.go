@@ -2,17 +2,19 @@ package htmx
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type Swap struct {
-	style       SwapStyle
-	transition  *bool
-	timing      *SwapTiming
-	scrolling   *SwapScrolling
-	ignoreTitle *bool
-	focusScroll *bool
+	style        SwapStyle
+	transition   *bool
+	swapTiming   *SwapTiming
+	settleTiming *SwapTiming
+	scrolling    *SwapScrolling
+	ignoreTitle  *bool
+	focusScroll  *bool
 }
 
 type SwapTiming struct {
@@ -21,15 +23,26 @@ type SwapTiming struct {
 }
 
 func (s *SwapTiming) String() string {
-	var out string
-
-	out = string(s.mode)
+	return string(s.mode) + ":" + formatInterval(s.duration)
+}
 
-	if s.duration != 0 {
-		out += ":" + s.duration.String()
+// formatInterval formats the duration the way htmx parses time intervals, whole seconds as "1s" and anything else as "500ms"
+func formatInterval(d time.Duration) string {
+	if d != 0 && d%time.Second == 0 {
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
 	}
 
-	return out
+	return strconv.FormatInt(d.Round(time.Millisecond).Milliseconds(), 10) + "ms"
+}
+
+// Mode returns whether the timing applies to the swap or the settle step
+func (s *SwapTiming) Mode() SwapTimingMode {
+	return s.mode
+}
+
+// Duration returns the delay of the timing
+func (s *SwapTiming) Duration() time.Duration {
+	return s.duration
 }
 
 type SwapScrolling struct {
@@ -54,6 +67,21 @@ func (s *SwapScrolling) String() string {
 	return out
 }
 
+// Mode returns whether the target is scrolled or shown
+func (s *SwapScrolling) Mode() SwapScrollingMode {
+	return s.mode
+}
+
+// Target returns the css selector of the element to scroll, empty for the target element itself
+func (s *SwapScrolling) Target() string {
+	return s.target
+}
+
+// Direction returns the direction to scroll to
+func (s *SwapScrolling) Direction() SwapDirection {
+	return s.direction
+}
+
 // NewSwap returns a new Swap
 func NewSwap() *Swap {
 	return &Swap{
@@ -112,6 +140,21 @@ func (s *Swap) ShowBottom(target ...string) *Swap {
 	return s.Show(SwapDirectionBottom, target...)
 }
 
+// ScrollWindow sets the scrolling behavior to scroll the window to the top or bottom
+func (s *Swap) ScrollWindow(direction SwapDirection) *Swap {
+	return s.Scroll(direction, ScrollTargetWindow)
+}
+
+// ShowWindow sets the scrolling behavior to show the top or bottom of the window
+func (s *Swap) ShowWindow(direction SwapDirection) *Swap {
+	return s.Show(direction, ScrollTargetWindow)
+}
+
+// ShowNone disables showing the target element after the swap, this overrides htmx.config.scrollIntoViewOnBoost
+func (s *Swap) ShowNone() *Swap {
+	return s.setScrolling(ScrollingShow, "", ScrollTargetNone)
+}
+
 // setTiming modifies the amount of time that htmx will wait after receiving a response to swap or settle the content
 func (s *Swap) setTiming(mode SwapTimingMode, swap ...time.Duration) *Swap {
 	var duration time.Duration
@@ -126,10 +169,17 @@ func (s *Swap) setTiming(mode SwapTimingMode, swap ...time.Duration) *Swap {
 		}
 	}
 
-	s.timing = &SwapTiming{
+	timing := &SwapTiming{
 		mode:     mode,
 		duration: duration,
 	}
+
+	if mode == TimingSettle {
+		s.settleTiming = timing
+	} else {
+		s.swapTiming = timing
+	}
+
 	return s
 }
 
@@ -184,13 +234,186 @@ func (s *Swap) String() string {
 		parts = append(parts, fmt.Sprintf("focus-scroll:%s", HxBoolToStr(*s.focusScroll)))
 	}
 
-	if s.timing != nil {
-		parts = append(parts, s.timing.String())
+	if s.swapTiming != nil {
+		parts = append(parts, s.swapTiming.String())
+	}
+
+	if s.settleTiming != nil {
+		parts = append(parts, s.settleTiming.String())
 	}
 
 	return strings.Join(parts, " ")
 }
 
+// Validate checks the swap style, the scroll targets and the durations so a broken swap never reaches the browser
+func (s *Swap) Validate() error {
+	if !s.style.valid() {
+		return fmt.Errorf("invalid swap style %q", s.style)
+	}
+
+	if s.scrolling != nil {
+		if err := s.scrolling.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, timing := range []*SwapTiming{s.swapTiming, s.settleTiming} {
+		if timing != nil && timing.duration < 0 {
+			return fmt.Errorf("invalid %s duration %v, it can not be negative", timing.mode, timing.duration)
+		}
+	}
+
+	return nil
+}
+
+// validate checks the scroll mode, target and direction
+func (s *SwapScrolling) validate() error {
+	if s.mode != ScrollingScroll && s.mode != ScrollingShow {
+		return fmt.Errorf("invalid scrolling mode %q", s.mode)
+	}
+
+	if strings.ContainsAny(s.target, " \t\r\n") {
+		return fmt.Errorf("invalid %s target %q, the selector can not contain whitespace", s.mode, s.target)
+	}
+
+	if s.target == ScrollTargetNone {
+		if s.mode != ScrollingShow || s.direction != "" {
+			return fmt.Errorf("invalid %s, the none target can only be used as show:none", s.String())
+		}
+
+		return nil
+	}
+
+	if s.direction != SwapDirectionTop && s.direction != SwapDirectionBottom {
+		return fmt.Errorf("invalid %s direction %q", s.mode, s.direction)
+	}
+
+	return nil
+}
+
+// GetStyle returns the style of the swap
+func (s *Swap) GetStyle() SwapStyle {
+	return s.style
+}
+
+// GetSwapTiming returns the swap delay, nil when it is not set
+func (s *Swap) GetSwapTiming() *SwapTiming {
+	return s.swapTiming
+}
+
+// GetSettleTiming returns the settle delay, nil when it is not set
+func (s *Swap) GetSettleTiming() *SwapTiming {
+	return s.settleTiming
+}
+
+// GetScrolling returns the scroll or show behavior, nil when it is not set
+func (s *Swap) GetScrolling() *SwapScrolling {
+	return s.scrolling
+}
+
+// GetTransition returns the transition setting, the second value reports whether it is set
+func (s *Swap) GetTransition() (bool, bool) {
+	return boolValue(s.transition)
+}
+
+// GetIgnoreTitle returns the ignoreTitle setting, the second value reports whether it is set
+func (s *Swap) GetIgnoreTitle() (bool, bool) {
+	return boolValue(s.ignoreTitle)
+}
+
+// GetFocusScroll returns the focus-scroll setting, the second value reports whether it is set
+func (s *Swap) GetFocusScroll() (bool, bool) {
+	return boolValue(s.focusScroll)
+}
+
+func boolValue(b *bool) (bool, bool) {
+	if b == nil {
+		return false, false
+	}
+
+	return *b, true
+}
+
+// ParseSwap parses a hx-swap value, as used in the HX-Reswap header, back into a Swap
+func ParseSwap(val string) (*Swap, error) {
+	s := NewSwap()
+
+	for i, field := range strings.Fields(val) {
+		modifier, value, found := strings.Cut(field, ":")
+		if !found {
+			if i > 0 {
+				return nil, fmt.Errorf("invalid swap modifier %q", field)
+			}
+
+			s.style = SwapStyle(field)
+			continue
+		}
+
+		switch modifier {
+		case TimingSwap.String(), TimingSettle.String():
+			duration, err := parseInterval(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid swap modifier %q: %w", field, err)
+			}
+
+			s.setTiming(SwapTimingMode(modifier), duration)
+		case ScrollingScroll.String(), ScrollingShow.String():
+			if value == "" {
+				return nil, fmt.Errorf("invalid swap modifier %q", field)
+			}
+
+			target, direction := "", value
+			if j := strings.LastIndexByte(value, ':'); j >= 0 {
+				target, direction = value[:j], value[j+1:]
+			}
+
+			if direction != SwapDirectionTop.String() && direction != SwapDirectionBottom.String() {
+				target, direction = value, ""
+			}
+
+			s.setScrolling(SwapScrollingMode(modifier), SwapDirection(direction), target)
+		case "transition", "ignoreTitle", "focus-scroll":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid swap modifier %q: %w", field, err)
+			}
+
+			switch modifier {
+			case "transition":
+				s.Transition(b)
+			case "ignoreTitle":
+				s.IgnoreTitle(b)
+			default:
+				s.FocusScroll(b)
+			}
+		default:
+			return nil, fmt.Errorf("unknown swap modifier %q", field)
+		}
+	}
+
+	return s, nil
+}
+
+// parseInterval parses a htmx time interval, a number without a unit is in milliseconds
+func parseInterval(val string) (time.Duration, error) {
+	unit := time.Millisecond
+	switch {
+	case strings.HasSuffix(val, "ms"):
+		val = strings.TrimSuffix(val, "ms")
+	case strings.HasSuffix(val, "s"):
+		val, unit = strings.TrimSuffix(val, "s"), time.Second
+	case strings.HasSuffix(val, "m"):
+		val, unit = strings.TrimSuffix(val, "m"), time.Minute
+	}
+
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(f * float64(unit)), nil
+}
+
 const (
 	// SwapInnerHTML replaces the inner html of the target element
 	SwapInnerHTML SwapStyle = "innerHTML"
@@ -215,14 +438,36 @@ const (
 
 	// SwapNone does not append content from response (out of band items will still be processed).
 	SwapNone SwapStyle = "none"
+
+	// SwapTextContent replaces the text content of the target element, without parsing the response as HTML
+	SwapTextContent SwapStyle = "textContent"
 )
 
+// SwapStyle is the way the response is swapped into the target.
+// Styles added by htmx extensions can be used by converting their name, for example SwapStyle("morph"),
+// add them to ExtensionSwapStyles to make them pass Validate.
 type SwapStyle string
 
 func (s SwapStyle) String() string {
 	return string(s)
 }
 
+// valid returns true for the swap styles htmx knows about
+func (s SwapStyle) valid() bool {
+	switch s {
+	case SwapInnerHTML, SwapOuterHTML, SwapBeforeBegin, SwapAfterBegin, SwapBeforeEnd, SwapAfterEnd, SwapDelete, SwapNone, SwapTextContent:
+		return true
+	}
+
+	for _, style := range ExtensionSwapStyles {
+		if s == style {
+			return true
+		}
+	}
+
+	return false
+}
+
 const (
 	// ScrollingScroll You can also change the scrolling behavior of the target element by using the scroll and show modifiers, both of which take the values top and bottom
 	ScrollingScroll SwapScrollingMode = "scroll"
@@ -233,6 +478,14 @@ const (
 
 type SwapScrollingMode string
 
+const (
+	// ScrollTargetWindow scrolls the window instead of an element
+	ScrollTargetWindow = "window"
+
+	// ScrollTargetNone disables scrolling, only valid for the show modifier
+	ScrollTargetNone = "none"
+)
+
 func (s SwapScrollingMode) String() string {
 	return string(s)
 }
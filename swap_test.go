@@ -42,8 +42,8 @@ func TestTimingSwap(t *testing.T) {
 	duration := 100 * time.Millisecond
 	swap := NewSwap().Swap(duration)
 
-	if swap.timing == nil || swap.timing.duration != duration {
-		t.Errorf("expected timing swap to be %v, got %v", duration, swap.timing.duration)
+	if swap.swapTiming == nil || swap.swapTiming.duration != duration {
+		t.Errorf("expected timing swap to be %v, got %v", duration, swap.swapTiming.duration)
 	}
 }
 
@@ -52,8 +52,8 @@ func TestTimingSettle(t *testing.T) {
 	duration := 200 * time.Millisecond
 	swap := NewSwap().Settle(duration)
 
-	if swap.timing == nil || swap.timing.duration != duration {
-		t.Errorf("expected timing settle to be %v, got %v", duration, swap.timing.duration)
+	if swap.settleTiming == nil || swap.settleTiming.duration != duration {
+		t.Errorf("expected timing settle to be %v, got %v", duration, swap.settleTiming.duration)
 	}
 }
 
@@ -151,3 +151,150 @@ func TestScrollingShowBottom(t *testing.T) {
 		t.Errorf("expected scrolling mode to be ScrollingShow, direction to be SwapDirectionBottom, and target to be %v, got mode: %v, direction: %v, target: %v", target, swap.scrolling.mode, swap.scrolling.direction, swap.scrolling.target)
 	}
 }
+
+// TestTimingSwapAndSettle tests that the swap and settle timing can be combined
+func TestTimingSwapAndSettle(t *testing.T) {
+	swap := NewSwap().Swap(100 * time.Millisecond).Settle(2 * time.Second)
+
+	expected := "innerHTML swap:100ms settle:2s"
+	if swap.String() != expected {
+		t.Errorf("expected string output to be %v, got %v", expected, swap.String())
+	}
+
+	expected = "innerHTML swap:0ms settle:20ms"
+	if swap := NewSwap().Swap().Settle(); swap.String() != expected {
+		t.Errorf("expected string output to be %v, got %v", expected, swap.String())
+	}
+
+	expected = "innerHTML swap:1500ms"
+	if swap := NewSwap().Swap(1500 * time.Millisecond); swap.String() != expected {
+		t.Errorf("expected string output to be %v, got %v", expected, swap.String())
+	}
+}
+
+// TestScrollTargets tests the window and none scroll targets
+func TestScrollTargets(t *testing.T) {
+	tests := map[string]*Swap{
+		"innerHTML show:none":          NewSwap().ShowNone(),
+		"innerHTML show:window:top":    NewSwap().ShowWindow(SwapDirectionTop),
+		"innerHTML scroll:window:top":  NewSwap().ScrollWindow(SwapDirectionTop),
+		"innerHTML scroll:#el:bottom":  NewSwap().ScrollBottom("#el"),
+		"textContent show:#el:top":     NewSwap().Style(SwapTextContent).ShowTop("#el"),
+		"beforeend scroll:bottom":      NewSwap().Style(SwapBeforeEnd).ScrollBottom(),
+		"outerHTML show:window:bottom": NewSwap().Style(SwapOuterHTML).ShowWindow(SwapDirectionBottom),
+	}
+
+	for expected, swap := range tests {
+		if swap.String() != expected {
+			t.Errorf("expected string output to be %v, got %v", expected, swap.String())
+		}
+
+		parsed, err := ParseSwap(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed.String() != expected {
+			t.Errorf("expected parsed output to be %v, got %v", expected, parsed.String())
+		}
+	}
+}
+
+// TestParseSwap tests parsing a swap value back into a Swap
+func TestParseSwap(t *testing.T) {
+	swap, err := ParseSwap("outerHTML show:#list:bottom transition:true ignoreTitle:false focus-scroll:true settle:1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if swap.GetStyle() != SwapOuterHTML {
+		t.Errorf("expected style to be SwapOuterHTML, got %v", swap.GetStyle())
+	}
+
+	scrolling := swap.GetScrolling()
+	if scrolling == nil || scrolling.Mode() != ScrollingShow || scrolling.Target() != "#list" || scrolling.Direction() != SwapDirectionBottom {
+		t.Errorf("expected scrolling to be show:#list:bottom, got %v", scrolling)
+	}
+
+	if transition, ok := swap.GetTransition(); !ok || !transition {
+		t.Error("expected transition to be true")
+	}
+
+	if ignoreTitle, ok := swap.GetIgnoreTitle(); !ok || ignoreTitle {
+		t.Error("expected ignoreTitle to be false")
+	}
+
+	if focusScroll, ok := swap.GetFocusScroll(); !ok || !focusScroll {
+		t.Error("expected focus-scroll to be true")
+	}
+
+	if settle := swap.GetSettleTiming(); settle == nil || settle.Duration() != time.Second {
+		t.Errorf("expected settle timing to be 1s, got %v", settle)
+	}
+}
+
+// TestParseSwapRoundTrip tests that the output of String parses into the same Swap
+func TestParseSwapRoundTrip(t *testing.T) {
+	swaps := []*Swap{
+		NewSwap(),
+		NewSwap().Style(SwapBeforeEnd).ScrollTop(),
+		NewSwap().ShowBottom("#messages").Swap(500 * time.Millisecond),
+		NewSwap().Style(SwapNone).Transition(false).IgnoreTitle(true),
+	}
+
+	for _, swap := range swaps {
+		parsed, err := ParseSwap(swap.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed.String() != swap.String() {
+			t.Errorf("expected %v, got %v", swap.String(), parsed.String())
+		}
+	}
+}
+
+// TestParseSwapInvalid tests that invalid modifiers are reported
+func TestParseSwapInvalid(t *testing.T) {
+	for _, val := range []string{"innerHTML scroll:", "innerHTML swap:fast", "innerHTML transition:maybe", "innerHTML unknown:true", "innerHTML outerHTML"} {
+		if _, err := ParseSwap(val); err == nil {
+			t.Errorf("expected an error for %q", val)
+		}
+	}
+}
+
+// TestSwapValidate tests the validation of the Swap
+func TestSwapValidate(t *testing.T) {
+	valid := []*Swap{
+		NewSwap(),
+		NewSwap().Style(SwapTextContent).ShowNone(),
+		NewSwap().ScrollWindow(SwapDirectionTop).Swap(time.Second).Settle(),
+	}
+
+	for _, swap := range valid {
+		if err := swap.Validate(); err != nil {
+			t.Errorf("expected %v to be valid, got %v", swap.String(), err)
+		}
+	}
+
+	invalid := []*Swap{
+		NewSwap().Style("innerHtml"),
+		NewSwap().ScrollTop("#list li"),
+		NewSwap().Scroll("middle"),
+		NewSwap().ScrollTop(ScrollTargetNone),
+		NewSwap().Swap(-time.Second),
+	}
+
+	for _, swap := range invalid {
+		if err := swap.Validate(); err == nil {
+			t.Errorf("expected %v to be invalid", swap.String())
+		}
+	}
+
+	ExtensionSwapStyles = []SwapStyle{"morph"}
+	defer func() { ExtensionSwapStyles = nil }()
+
+	if err := NewSwap().Style("morph").Validate(); err != nil {
+		t.Errorf("expected extension style to be valid, got %v", err)
+	}
+}
@@ -1,20 +1,37 @@
 package htmx
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
+	"unicode"
 )
 
 type eventContent struct {
-	event string
-	data  any
+	event  string
+	data   any
+	simple bool
 }
 
 type Trigger struct {
 	triggers   []eventContent
 	onlySimple bool
+	duplicates TriggerDuplicatePolicy
 }
 
+// TriggerDuplicatePolicy decides how an event that is added more than once to a Trigger set is encoded
+type TriggerDuplicatePolicy int
+
+const (
+	// TriggerDuplicateMerge triggers a duplicate event once, the details of all occurrences are merged into an array
+	TriggerDuplicateMerge TriggerDuplicatePolicy = iota
+
+	// TriggerDuplicateError makes Encode return an error when an event is added more than once
+	TriggerDuplicateError
+)
+
 // NewTrigger returns a new Trigger set
 func NewTrigger() *Trigger {
 	return &Trigger{
@@ -23,6 +40,12 @@ func NewTrigger() *Trigger {
 	}
 }
 
+// OnDuplicate sets the policy for events that are added more than once, the default is TriggerDuplicateMerge
+func (t *Trigger) OnDuplicate(policy TriggerDuplicatePolicy) *Trigger {
+	t.duplicates = policy
+	return t
+}
+
 // add adds a trigger to the Trigger set
 func (t *Trigger) add(trigger eventContent) *Trigger {
 	t.triggers = append(t.triggers, trigger)
@@ -31,7 +54,7 @@ func (t *Trigger) add(trigger eventContent) *Trigger {
 }
 
 func (t *Trigger) AddEvent(event string) *Trigger {
-	return t.add(eventContent{event: event, data: ""})
+	return t.add(eventContent{event: event, data: "", simple: true})
 }
 
 // AddEventDetailed adds a trigger to the Trigger set
@@ -48,6 +71,18 @@ func (t *Trigger) AddEventObject(event string, details map[string]any) *Trigger
 	return t.add(eventContent{event: event, data: details})
 }
 
+// Merge adds the events of the other Trigger set to the Trigger set
+func (t *Trigger) Merge(other *Trigger) *Trigger {
+	if other == nil {
+		return t
+	}
+
+	t.triggers = append(t.triggers, other.triggers...)
+	t.onlySimple = t.onlySimple && other.onlySimple
+
+	return t
+}
+
 func (t *Trigger) AddSuccess(message string, vars ...map[string]any) {
 	t.addNotifyObject(notificationSuccess, message, vars...)
 }
@@ -84,24 +119,173 @@ func (t *Trigger) addNotifyObject(nt notificationType, message string, vars ...m
 	return t.AddEventObject(DefaultNotificationKey, details)
 }
 
-// String returns the string representation of the Trigger set
+// Events returns the names of the events in the order they were first added
+func (t *Trigger) Events() []string {
+	var names []string
+	seen := make(map[string]bool)
+
+	for _, tr := range t.triggers {
+		if !seen[tr.event] {
+			seen[tr.event] = true
+			names = append(names, tr.event)
+		}
+	}
+
+	return names
+}
+
+// Detail returns the detail of the event as it is encoded, the second value reports whether the event is part of the Trigger set
+func (t *Trigger) Detail(event string) (any, bool) {
+	var details []any
+	found := false
+
+	for _, tr := range t.triggers {
+		if tr.event != event {
+			continue
+		}
+
+		found = true
+		if !tr.simple {
+			details = append(details, tr.data)
+		}
+	}
+
+	switch len(details) {
+	case 0:
+		return "", found
+	case 1:
+		return details[0], found
+	default:
+		return details, found
+	}
+}
+
+// IsSimple returns true when none of the events carry details and the Trigger set is encoded as a comma separated list
+func (t *Trigger) IsSimple() bool {
+	return t.onlySimple
+}
+
+// Validate checks the event names and makes sure the Trigger set can be encoded
+func (t *Trigger) Validate() error {
+	for _, tr := range t.triggers {
+		if tr.event == "" {
+			return errors.New("trigger event name can not be empty")
+		}
+
+		if strings.ContainsFunc(tr.event, func(r rune) bool { return r == ',' || unicode.IsSpace(r) || unicode.IsControl(r) }) {
+			return fmt.Errorf("invalid trigger event name %q, it can not contain whitespace or commas", tr.event)
+		}
+	}
+
+	_, err := t.Encode()
+	return err
+}
+
+// String returns the string representation of the Trigger set, it is empty when the Trigger set can not be encoded
 func (t *Trigger) String() string {
+	out, err := t.Encode()
+	if err != nil {
+		return ""
+	}
+
+	return out
+}
+
+// Encode returns the header value of the Trigger set.
+// Events are encoded in the order they were added, simple events are comma separated as long as no event carries details.
+// Once an event has details, the Trigger set is encoded as a json object where simple events have an empty detail.
+func (t *Trigger) Encode() (string, error) {
+	names := t.Events()
+
+	if t.duplicates == TriggerDuplicateError && len(names) != len(t.triggers) {
+		seen := make(map[string]bool)
+		for _, tr := range t.triggers {
+			if seen[tr.event] {
+				return "", fmt.Errorf("duplicate trigger event %q", tr.event)
+			}
+			seen[tr.event] = true
+		}
+	}
+
 	if t.onlySimple {
-		data := make([]string, len(t.triggers))
+		return strings.Join(names, ", "), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		detail, _ := t.Detail(name)
 
-		for i, trigger := range t.triggers {
-			data[i] = trigger.event
+		key, err := json.Marshal(name)
+		if err != nil {
+			return "", err
 		}
 
-		return strings.Join(data, ", ")
+		value, err := json.Marshal(detail)
+		if err != nil {
+			return "", fmt.Errorf("trigger event %q: %w", name, err)
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
 	}
+	buf.WriteByte('}')
 
-	triggerMap := make(map[string]any)
-	for _, tr := range t.triggers {
-		triggerMap[tr.event] = tr.data
+	return buf.String(), nil
+}
+
+// ParseTrigger parses a HX-Trigger header value back into a Trigger set.
+// Both the comma separated form and the json object form are supported, the order of the events is preserved.
+func ParseTrigger(val string) (*Trigger, error) {
+	t := NewTrigger()
+	val = strings.TrimSpace(val)
+
+	if !strings.HasPrefix(val, "{") {
+		for _, event := range strings.Split(val, ",") {
+			if event = strings.TrimSpace(event); event != "" {
+				t.AddEvent(event)
+			}
+		}
+
+		return t, nil
 	}
-	data, _ := json.Marshal(triggerMap)
-	return string(data)
+
+	dec := json.NewDecoder(strings.NewReader(val))
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("invalid trigger: %w", err)
+	}
+
+	// decode the object token by token to keep the order of the events
+	t.onlySimple = false
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("invalid trigger: %w", err)
+		}
+
+		name, ok := token.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid trigger: unexpected %v", token)
+		}
+
+		var data any
+		if err = dec.Decode(&data); err != nil {
+			return nil, fmt.Errorf("invalid trigger: %w", err)
+		}
+
+		t.add(eventContent{event: name, data: data})
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("invalid trigger: %w", err)
+	}
+
+	return t, nil
 }
 
 const (
@@ -2,6 +2,7 @@ package htmx
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -17,7 +18,7 @@ func TestNewTriggerMixed(t *testing.T) {
 		AddEventDetailed("qux", "quux").
 		AddEventObject("corge", map[string]any{"grault": "garply", "waldo": "fred", "plugh": "xyzzy", "thud": "wibble"})
 
-	expected := `{"bar":"baz","corge":{"grault":"garply","plugh":"xyzzy","thud":"wibble","waldo":"fred"},"foo":"","qux":"quux"}`
+	expected := `{"foo":"","bar":"baz","qux":"quux","corge":{"grault":"garply","plugh":"xyzzy","thud":"wibble","waldo":"fred"}}`
 
 	if trigger.String() != expected {
 		t.Errorf("expected trigger to be %v, got %v", expected, trigger.String())
@@ -53,7 +54,7 @@ func TestNewTriggerMixedNested(t *testing.T) {
 		AddEventDetailed("bar", "baz").
 		AddEventDetailed("qux", "quux").
 		AddEventObject("corge", map[string]any{"grault": "garply", "waldo": "fred", "plugh": "xyzzy", "thud": map[string]any{"foo": "bar", "baz": "qux"}}).AddSuccess("successfully tested", map[string]any{"foo": "bar", "baz": "qux"})
-	expected := `{"bar":"baz","corge":{"grault":"garply","plugh":"xyzzy","thud":{"baz":"qux","foo":"bar"},"waldo":"fred"},"foo":"","qux":"quux","showMessage":{"baz":"qux","foo":"bar","level":"success","message":"successfully tested"}}`
+	expected := `{"foo":"","bar":"baz","qux":"quux","corge":{"grault":"garply","plugh":"xyzzy","thud":{"baz":"qux","foo":"bar"},"waldo":"fred"},"showMessage":{"baz":"qux","foo":"bar","level":"success","message":"successfully tested"}}`
 
 	if trigger.String() != expected {
 		t.Errorf("expected trigger to be %v, got %v", expected, trigger.String())
@@ -99,3 +100,123 @@ func TestTriggerWarning(t *testing.T) {
 
 	equal(t, expected, handler.response.Get(HXTrigger))
 }
+
+func TestTriggerAccumulate(t *testing.T) {
+	req := &http.Request{}
+	handler := New().NewHandler(dummyWriter{}, req)
+
+	handler.Trigger("cartUpdated")
+	handler.TriggerWithObject(NewTrigger().AddEvent("itemAdded"))
+	handler.TriggerSuccess("saved")
+	handler.TriggerAfterSwap("swapped")
+	handler.TriggerAfterSwapWithObject(NewTrigger().AddEvent("refreshed"))
+
+	equal(t, `{"cartUpdated":"","itemAdded":"","showMessage":{"level":"success","message":"saved"}}`, handler.response.Get(HXTrigger))
+	equal(t, "swapped, refreshed", handler.response.Get(HXTriggerAfterSwap))
+	equal(t, "", handler.response.Get(HXTriggerAfterSettle))
+}
+
+func TestTriggerAccumulateJSON(t *testing.T) {
+	req := &http.Request{}
+	handler := New().NewHandler(dummyWriter{}, req)
+
+	handler.Trigger(`{"foo":{"bar":"baz"}}`)
+	handler.Trigger("qux")
+
+	equal(t, `{"foo":{"bar":"baz"},"qux":""}`, handler.response.Get(HXTrigger))
+}
+
+func TestTriggerDuplicateMerge(t *testing.T) {
+	trigger := NewTrigger().
+		AddEvent("foo").
+		AddEventDetailed("bar", "baz").
+		AddEvent("foo").
+		AddEventDetailed("bar", "qux")
+
+	equal(t, `{"foo":"","bar":["baz","qux"]}`, trigger.String())
+
+	equal(t, "foo, bar", NewTrigger().AddEvent("foo").AddEvent("bar").AddEvent("foo").String())
+}
+
+func TestTriggerDuplicateError(t *testing.T) {
+	trigger := NewTrigger().
+		OnDuplicate(TriggerDuplicateError).
+		AddEvent("foo").
+		AddEvent("foo")
+
+	_, err := trigger.Encode()
+	if err == nil {
+		t.Error("expected an error for a duplicate event")
+	}
+
+	equal(t, "", trigger.String())
+}
+
+func TestParseTrigger(t *testing.T) {
+	trigger, err := ParseTrigger("foo, bar,baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equalBool(t, true, trigger.IsSimple())
+	equal(t, "foo, bar, baz", trigger.String())
+
+	trigger, err = ParseTrigger(`{"foo":"","bar":"baz","showMessage":{"level":"info","message":"hi"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equalBool(t, false, trigger.IsSimple())
+	equal(t, "foo,bar,showMessage", strings.Join(trigger.Events(), ","))
+
+	detail, ok := trigger.Detail("bar")
+	equalBool(t, true, ok)
+	equal(t, "baz", detail.(string))
+
+	detail, _ = trigger.Detail("showMessage")
+	equal(t, "info", detail.(map[string]any)["level"].(string))
+
+	_, ok = trigger.Detail("qux")
+	equalBool(t, false, ok)
+
+	equal(t, `{"foo":"","bar":"baz","showMessage":{"level":"info","message":"hi"}}`, trigger.String())
+
+	if _, err = ParseTrigger(`{"foo":`); err == nil {
+		t.Error("expected an error for invalid json")
+	}
+}
+
+func TestTriggerValidate(t *testing.T) {
+	if err := NewTrigger().AddEvent("foo").AddEventDetailed("bar:baz", "qux").Validate(); err != nil {
+		t.Errorf("expected trigger to be valid, got %v", err)
+	}
+
+	invalid := []*Trigger{
+		NewTrigger().AddEvent(""),
+		NewTrigger().AddEvent("foo bar"),
+		NewTrigger().AddEvent("foo,bar"),
+		NewTrigger().OnDuplicate(TriggerDuplicateError).AddEvent("foo").AddEvent("foo"),
+	}
+
+	for _, trigger := range invalid {
+		if err := trigger.Validate(); err == nil {
+			t.Errorf("expected %v to be invalid", trigger.triggers)
+		}
+	}
+}
+
+func TestTriggerWithObjectInvalid(t *testing.T) {
+	req := &http.Request{}
+	handler := New().NewHandler(dummyWriter{}, req)
+
+	if err := handler.TryTriggerWithObject(NewTrigger().AddEvent("foo bar")); err == nil {
+		t.Error("expected an error for an invalid event name")
+	}
+
+	if err := handler.TryReSwapWithObject(NewSwap().Style("innerHtml")); err == nil {
+		t.Error("expected an error for an invalid swap style")
+	}
+
+	equal(t, "", handler.response.Get(HXTrigger))
+	equal(t, "", handler.response.Get(HXReswap))
+}
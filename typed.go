@@ -0,0 +1,133 @@
+package htmx
+
+import (
+	"html/template"
+	"io/fs"
+)
+
+// TypedComponent is a component with a typed model, templates access the model as {{ .Model }}.
+// Renamed or missing fields of the model are reported by the template instead of rendering an empty value,
+// which is what happens with a missing key of the data map.
+// Data, global data, partials and the url keep working as they do for a Component, and typed and untyped
+// components can wrap each other and be used as partials of each other.
+// Wrappers and partials without a model of their own render with the model of the component.
+type TypedComponent[T any] struct {
+	*Component
+}
+
+// NewTypedComponent returns a new component with a model of type T
+func NewTypedComponent[T any](templates ...string) *TypedComponent[T] {
+	return &TypedComponent[T]{
+		Component: NewComponent(templates...),
+	}
+}
+
+// SetModel sets the model of the component
+func (c *TypedComponent[T]) SetModel(model T) *TypedComponent[T] {
+	c.modelValue = model
+	c.hasModel = true
+
+	return c
+}
+
+// Model returns the model of the component, the zero value of T when no model is set
+func (c *TypedComponent[T]) Model() T {
+	model, _ := c.modelValue.(T)
+	return model
+}
+
+// Instance returns a per-request copy of the component, see Component.Instance
+func (c *TypedComponent[T]) Instance() RenderableComponent {
+	return c.instance()
+}
+
+// InstanceWithModel returns a per-request copy of the component with the given model
+func (c *TypedComponent[T]) InstanceWithModel(model T) *TypedComponent[T] {
+	return c.instance().SetModel(model)
+}
+
+// instance returns a typed per-request copy of the component
+func (c *TypedComponent[T]) instance() *TypedComponent[T] {
+	return &TypedComponent[T]{
+		Component: c.Component.Instance().(*Component),
+	}
+}
+
+// FS sets the filesystem to load templates from
+func (c *TypedComponent[T]) FS(fsys fs.FS) *TypedComponent[T] {
+	c.Component.FS(fsys)
+	return c
+}
+
+// FuncSetKey identifies the template functions of the component for the template cache, see Component.FuncSetKey
+func (c *TypedComponent[T]) FuncSetKey(key string) *TypedComponent[T] {
+	c.Component.FuncSetKey(key)
+	return c
+}
+
+// Wrap wraps the component with the given renderer
+func (c *TypedComponent[T]) Wrap(renderer RenderableComponent, target string) RenderableComponent {
+	c.Component.Wrap(renderer, target)
+	return c
+}
+
+// With adds a partial to the component
+func (c *TypedComponent[T]) With(r RenderableComponent, target string) RenderableComponent {
+	c.Component.With(r, target)
+	return c
+}
+
+// Attach adds a template to the main component but doesn't pre-render it
+func (c *TypedComponent[T]) Attach(target string) RenderableComponent {
+	c.Component.Attach(target)
+	return c
+}
+
+// Target renders only the named block when the HX-Target of a partial request matches the target id
+func (c *TypedComponent[T]) Target(target string, block string) RenderableComponent {
+	c.Component.Target(target, block)
+	return c
+}
+
+// Slot fills the named slot of the wrappers with the named block of the templates of the component
+func (c *TypedComponent[T]) Slot(name string, block string) RenderableComponent {
+	c.Component.Slot(name, block)
+	return c
+}
+
+// SlotComponent fills the named slot of the wrappers with the output of the given component
+func (c *TypedComponent[T]) SlotComponent(name string, r RenderableComponent) RenderableComponent {
+	c.Component.SlotComponent(name, r)
+	return c
+}
+
+func (c *TypedComponent[T]) AddTemplateFunction(name string, function interface{}) RenderableComponent {
+	c.Component.AddTemplateFunction(name, function)
+	return c
+}
+
+func (c *TypedComponent[T]) AddTemplateFunctions(funcs template.FuncMap) RenderableComponent {
+	c.Component.AddTemplateFunctions(funcs)
+	return c
+}
+
+func (c *TypedComponent[T]) SetGlobalData(input map[string]any) RenderableComponent {
+	c.Component.SetGlobalData(input)
+	return c
+}
+
+func (c *TypedComponent[T]) AddGlobalData(key string, value any) RenderableComponent {
+	c.Component.AddGlobalData(key, value)
+	return c
+}
+
+// SetData adds data to the component
+func (c *TypedComponent[T]) SetData(input map[string]any) RenderableComponent {
+	c.Component.SetData(input)
+	return c
+}
+
+func (c *TypedComponent[T]) AddData(key string, value any) RenderableComponent {
+	c.Component.AddData(key, value)
+	return c
+}
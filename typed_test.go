@@ -0,0 +1,64 @@
+package htmx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+type testProduct struct {
+	Name  string
+	Price int
+}
+
+var typedFS = fstest.MapFS{
+	"layout.html":  {Data: []byte(`<title>{{ .Model.Name }}</title>{{ .Partials.Content }}`)},
+	"product.html": {Data: []byte(`<h1>{{ .Model.Name }}</h1>{{ .Partials.Price }}<p>{{ .Data.Note }}</p><p>{{ .Global.Shop }}</p>`)},
+	"price.html":   {Data: []byte(`<span>{{ .Model.Price }}</span>`)},
+	"user.html":    {Data: []byte(`<b>{{ .Model.Name }}</b>`)},
+	"page.html":    {Data: []byte(`<main>{{ .Data.Title }}{{ .Partials.User }}</main>`)},
+	"renamed.html": {Data: []byte(`{{ .Model.Title }}`)},
+}
+
+func TestTypedComponent(t *testing.T) {
+	product := NewTypedComponent[testProduct]("product.html").FS(typedFS)
+	product.With(NewComponent("price.html").FS(typedFS), "Price")
+	product.Wrap(NewComponent("layout.html").FS(typedFS), "Content")
+
+	instance := product.InstanceWithModel(testProduct{Name: "Lamp", Price: 42})
+	instance.AddData("Note", "new")
+	instance.AddGlobalData("Shop", "htmx")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if _, err := New().NewHandler(w, r).Render(context.Background(), instance); err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, `<title>Lamp</title><h1>Lamp</h1><span>42</span><p>new</p><p>htmx</p>`, w.Body.String())
+	equal(t, "Lamp", instance.Model().Name)
+	equal(t, "", product.Model().Name)
+}
+
+func TestTypedComponentPartial(t *testing.T) {
+	user := NewTypedComponent[testProduct]("user.html").FS(typedFS).SetModel(testProduct{Name: "Ada"})
+	page := NewComponent("page.html").FS(typedFS).AddData("Title", "Users").With(user, "User")
+
+	out, err := page.Render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equal(t, `<main>Users<b>Ada</b></main>`, string(out))
+}
+
+func TestTypedComponentRenamedField(t *testing.T) {
+	_, err := NewTypedComponent[testProduct]("renamed.html").FS(typedFS).SetModel(testProduct{}).Render(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Title") {
+		t.Errorf("expected an error for the missing field, got %v", err)
+	}
+}